@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/Sirupsen/logrus"
+	"github.com/xeia/Kings-Raid-Crawler/crawler"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+)
+
+const (
+	// bodies larger than this are not stored, keeping articles well within
+	// the item size limit of dynamo
+	maxArticleBody = 64 << 10
+
+	articleBodyErr = "article body too large"
+)
+
+// fetchArticleBody adds the body of the article's page, as sanitized HTML and
+// as markdown. Images in the body are mirrored when mirroring is enabled. The
+// article is stored without a body when it cannot be fetched.
+func fetchArticleBody(ctx context.Context, article models.Article) models.Article {
+	body, err := crawler.Default.ArticleBody(ctx, article.ID, mirrorImages(ctx, article.ID))
+	if err == nil && len(body) > maxArticleBody {
+		err = errors.New(articleBodyErr)
+	}
+	var md string
+	if err == nil {
+		md, err = crawler.Markdown(body, crawler.GitHubMarkdown)
+	}
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"ArticleID": article.ID,
+		}).Warn("Body Error :", err.Error())
+		return article
+	}
+
+	article.Body, article.BodyHTML = md, body
+	return article
+}
+
+// articleDescription renders the article's body in the flavor of a notifier,
+// falling back to the escaped preview teaser for articles without a body
+func articleDescription(a models.Article, f crawler.Flavor) string {
+	if a.BodyHTML != "" {
+		md, err := crawler.Markdown(a.BodyHTML, f)
+		if err == nil && md != "" {
+			return md
+		}
+	}
+	return crawler.Escape(a.Desc, f)
+}
@@ -0,0 +1,582 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/guregu/dynamo"
+	"github.com/spf13/cobra"
+	"github.com/xeia/Kings-Raid-Crawler/crawler"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	articleIDErr    = "expected an article id"
+	scrapeFailedErr = "some categories could not be scraped"
+)
+
+// subscribersCommand returns the `subscribers` command used to manage
+// webhook subscriptions from the command line
+func subscribersCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "subscribers",
+		Short: "Manage webhook subscriptions",
+	}
+
+	var sub models.Subscription
+	var types, regions, keywords string
+	addCmd := &cobra.Command{
+		Use:   "add",
+		Short: "Add a webhook subscription",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			sub.Types, err = parseArticleTypes(types)
+			if err != nil {
+				return err
+			}
+			sub.Regions = splitList(regions)
+			sub.Keywords = splitList(keywords)
+
+			sub, err = addSubscriptionToDB(sub)
+			if err != nil {
+				return err
+			}
+			return printJSON(sub)
+		},
+	}
+	addCmd.Flags().StringVar(&sub.Name, "name", "", "Name of the subscriber")
+	addCmd.Flags().StringVar(&sub.Kind, "kind", models.SubscriptionDiscord, "Kind of subscriber (discord, webhook, slack, matrix, email)")
+	addCmd.Flags().StringVar(&sub.WebhookURL, "url", "", "Webhook URL, or homeserver URL for matrix")
+	addCmd.Flags().StringVar(&sub.Email, "email", "", "Recipient address for email")
+	addCmd.Flags().StringVar(&sub.RoomID, "room", "", "Matrix room ID")
+	addCmd.Flags().StringVar(&sub.Token, "token", "", "Matrix access token")
+	addCmd.Flags().BoolVar(&sub.Thumbnails, "thumbnails", false, "Upload article thumbnails to the matrix room")
+	addCmd.Flags().StringVar(&sub.Secret, "secret", "", "HMAC secret for generic webhooks and unsubscribe links, generated if empty")
+	addCmd.Flags().StringVar(&sub.Frequency, "frequency", models.FrequencyImmediate, "Delivery frequency (immediate, daily, weekly)")
+	addCmd.Flags().StringVar(&types, "types", "", "Comma separated article types (notice,events,patch)")
+	addCmd.Flags().StringVar(&regions, "regions", "", "Comma separated cafe regions")
+	addCmd.Flags().StringVar(&keywords, "keywords", "", "Comma separated title keywords")
+	addCmd.Flags().BoolVar(&sub.StaffReplies, "staff-replies", false, "Also notify new staff replies on tracked articles")
+
+	removeCmd := &cobra.Command{
+		Use:   "remove <id>",
+		Short: "Remove a webhook subscription",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New(subscriptionIDErr)
+			}
+			return removeSubscriptionFromDB(args[0])
+		},
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List webhook subscriptions",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			subs, err := getSubscriptionsFromDB()
+			if err != nil {
+				return err
+			}
+			for i := range subs {
+				subs[i] = subs[i].Redacted()
+			}
+			return printJSON(subs)
+		},
+	}
+
+	testCmd := &cobra.Command{
+		Use:   "test <id>",
+		Short: "Send a sample article to a subscriber",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return errors.New(subscriptionIDErr)
+			}
+			sub, err := getSubscriptionFromDB(args[0])
+			if err != nil {
+				return err
+			}
+
+			notify, ok := notifiers[subscriptionKind(sub)]
+			if !ok {
+				return fmt.Errorf("%s: %s", subscriptionKindErr, sub.Kind)
+			}
+			e := models.ArticleEvent{
+				ID:        "test",
+				Type:      models.ArticleNew,
+				Timestamp: time.Now(),
+				Article:   sampleArticle,
+			}
+			return notify(sub, []models.ArticleEvent{e}, logrus.New())[0]
+		},
+	}
+
+	cmd.AddCommand(addCmd, removeCmd, listCmd, testCmd)
+	return cmd
+}
+
+// vapidCommand returns the `vapid` command generating the key pair used to sign web push messages
+func vapidCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "vapid",
+		Short: "Generate a VAPID key pair for web push",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			priv, pub, err := generateVAPIDKey()
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s=%s\n", envVAPIDPrivateKey, priv)
+			fmt.Printf("# public key, served at GET /push: %s\n", pub)
+			return nil
+		},
+	}
+}
+
+// fixturesCommand returns the `fixtures` command used to record cafe pages
+// and check the crawler against them offline
+func fixturesCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "fixtures",
+		Short: "Record and verify the crawler's HTML fixtures",
+	}
+
+	var dir string
+	cmd.PersistentFlags().StringVar(&dir, "dir", crawler.FixturesDir, "Directory of the recorded pages and golden files")
+
+	recordCmd := &cobra.Command{
+		Use:   "record",
+		Short: "Record every menu from the live cafe and regenerate the golden files",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return crawler.RecordFixtures(crawler.ResolveFixturesDir(dir), crawler.Default.Rules)
+		},
+	}
+
+	var update bool
+	verifyCmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Check the articles parsed from the recorded pages against the golden files",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			diffs, err := crawler.VerifyFixtures(crawler.ResolveFixturesDir(dir), crawler.Default.Rules, update)
+			if err != nil {
+				return err
+			}
+			for _, d := range diffs {
+				fmt.Println(d)
+			}
+			if len(diffs) > 0 {
+				return fmt.Errorf("%d difference(s) from the golden files", len(diffs))
+			}
+			return nil
+		},
+	}
+	verifyCmd.Flags().BoolVar(&update, "update", false, "Rewrite the golden files from the recorded pages")
+
+	cmd.AddCommand(recordCmd, verifyCmd)
+	return cmd
+}
+
+// testSelectorsCommand returns the `test-selectors` command checking
+// extraction rules against the recorded cafe pages
+func testSelectorsCommand() *cobra.Command {
+	var dir string
+	cmd := &cobra.Command{
+		Use:   "test-selectors [rules.json]",
+		Short: "Check extraction rules against the recorded cafe pages",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := crawler.ResolveFixturesDir(dir)
+			rules := crawler.Default.Rules
+			if len(args) == 1 {
+				r, err := crawler.LoadRules(args[0])
+				if err != nil {
+					return err
+				}
+				rules = r
+			}
+
+			c := &crawler.Crawler{BaseURL: crawler.CafeBase, Fetcher: crawler.FileFetcher{Dir: dir}, Rules: rules}
+			for typ := models.NOTICE; typ <= models.PATCHNOTES; typ++ {
+				articles, _, _, err := c.Scrape(context.Background(), typ)
+				if _, ok := err.(*crawler.LayoutError); err != nil && !ok {
+					return err
+				}
+
+				fmt.Printf("%s: %d article(s)\n", typ.String(), len(articles))
+				for _, a := range articles {
+					fmt.Printf("  %d\t%s\n", a.ID, a.Title)
+					if a.ImgURL != "" {
+						fmt.Printf("  \timage: %s\n", a.ImgURL)
+					}
+				}
+			}
+
+			diffs, err := crawler.VerifyFixtures(dir, rules, false)
+			if err != nil {
+				return err
+			}
+			for _, d := range diffs {
+				fmt.Println(d)
+			}
+			if len(diffs) > 0 {
+				return fmt.Errorf("%d problem(s) found", len(diffs))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&dir, "dir", crawler.FixturesDir, "Directory of the recorded pages and golden files")
+	return cmd
+}
+
+// scrapeCommand returns the `scrape` command, scraping and storing categories
+// like the scheduled scrape
+func scrapeCommand() *cobra.Command {
+	var timeout time.Duration
+	cmd := &cobra.Command{
+		Use:   "scrape [types]",
+		Short: "Scrape and store the given categories, or every category",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			types := []models.ArticleType{models.EVENTS, models.NOTICE, models.PATCHNOTES}
+			if len(args) == 1 {
+				var err error
+				types, err = parseArticleTypes(args[0])
+				if err != nil {
+					return err
+				}
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			report := scrapeCategories(ctx, types, logrus.New())
+			if err := printJSON(report); err != nil {
+				return err
+			}
+			if report.Failed() {
+				return errors.New(scrapeFailedErr)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().DurationVar(&timeout, "timeout", 5*time.Minute, "Time allowed for the scrape")
+	return cmd
+}
+
+// backfillCommand returns the `backfill` command, adding the bodies, mirrored
+// thumbnails and snapshots missing from articles stored before they existed
+func backfillCommand() *cobra.Command {
+	var types string
+	var bodies, images, snapshots bool
+	cmd := &cobra.Command{
+		Use:   "backfill",
+		Short: "Add missing bodies, mirrored thumbnails and snapshots to stored articles",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			articles, err := getStoredArticles(types)
+			if err != nil {
+				return err
+			}
+
+			ctx := context.Background()
+			failed := 0
+			for _, a := range articles {
+				updated := a
+				if images && a.OriginalImgURL == "" {
+					updated = mirrorArticleImage(ctx, updated, models.Article{})
+				}
+				if bodies && a.BodyHTML == "" {
+					updated = fetchArticleBody(ctx, updated)
+				}
+
+				var changes []string
+				if updated.OriginalImgURL != a.OriginalImgURL {
+					changes = append(changes, "thumbnail")
+				}
+				if updated.BodyHTML != a.BodyHTML {
+					changes = append(changes, "body")
+				}
+				if len(changes) > 0 {
+					if err := putArticleToDB(updated); err != nil {
+						fmt.Printf("%d\terror: %s\n", a.ID, err.Error())
+						failed++
+						continue
+					}
+				}
+
+				if snapshots && snapshotStore != nil {
+					if err := captureSnapshot(ctx, updated); err != nil {
+						fmt.Printf("%d\tsnapshot error: %s\n", a.ID, err.Error())
+						failed++
+					}
+				}
+				if len(changes) > 0 {
+					fmt.Printf("%d\t%s\n", a.ID, strings.Join(changes, ", "))
+				}
+			}
+
+			if failed > 0 {
+				return fmt.Errorf("%d article(s) could not be backfilled", failed)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&types, "types", "", "Comma separated article types (notice,events,patch)")
+	cmd.Flags().BoolVar(&bodies, "bodies", true, "Fetch missing article bodies")
+	cmd.Flags().BoolVar(&images, "images", true, "Mirror thumbnails that are not mirrored yet")
+	cmd.Flags().BoolVar(&snapshots, "snapshots", true, "Capture snapshots of revisions not archived yet")
+	return cmd
+}
+
+// listCommand returns the `list` command printing the latest stored articles
+func listCommand() *cobra.Command {
+	var types string
+	var limit int
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the latest stored articles",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			articles, err := getStoredArticles(types)
+			if err != nil {
+				return err
+			}
+			if limit > 0 && len(articles) > limit {
+				articles = articles[:limit]
+			}
+
+			tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(tw, "ID\tTYPE\tREV\tPUBLISHED\tTITLE")
+			for _, a := range articles {
+				fmt.Fprintf(tw, "%d\t%s\t%d\t%s\t%s\n", a.ID, a.Type.String(), a.Revision,
+					articlePublishedOn(a).Format("2006-01-02 15:04"), a.Title)
+			}
+			return tw.Flush()
+		},
+	}
+	cmd.Flags().StringVar(&types, "types", "", "Comma separated article types (notice,events,patch)")
+	cmd.Flags().IntVar(&limit, "limit", 20, "Maximum number of articles, 0 for all")
+	return cmd
+}
+
+// showCommand returns the `show` command printing a stored article along with
+// its reactions, comments and snapshots
+func showCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "show <id>",
+		Short: "Show a stored article with its reactions, comments and snapshots",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseArticleID(args)
+			if err != nil {
+				return err
+			}
+			a, err := getArticleFromDB(id)
+			if err != nil {
+				return err
+			}
+
+			out := struct {
+				Article   models.Article       `json:"article"`
+				Stats     *models.ArticleStats `json:"stats,omitempty"`
+				Comments  []models.Comment     `json:"comments,omitempty"`
+				Snapshots []models.Snapshot    `json:"snapshots,omitempty"`
+			}{Article: a}
+
+			stats, err := getArticleStatsFromDB(id)
+			if err == nil {
+				out.Stats = &stats
+			} else if err != dynamo.ErrNotFound {
+				return err
+			}
+			if out.Comments, err = getCommentsFromDB(id); err != nil {
+				return err
+			}
+			if out.Snapshots, err = getSnapshotsFromDB(id); err != nil {
+				return err
+			}
+			return printJSON(out)
+		},
+	}
+}
+
+// notifyCommand returns the `notify` command sending a stored article to the
+// subscribers that were not notified of its current revision, or with
+// --replay to every matching subscriber again
+func notifyCommand() *cobra.Command {
+	var replay bool
+	var subID string
+	cmd := &cobra.Command{
+		Use:   "notify <id>",
+		Short: "Notify subscribers of a stored article",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			id, err := parseArticleID(args)
+			if err != nil {
+				return err
+			}
+			a, err := getArticleFromDB(id)
+			if err != nil {
+				return err
+			}
+
+			e := models.ArticleEvent{
+				ID:        fmt.Sprintf("cli-%d-%d", a.ID, a.Revision),
+				Type:      models.ArticleNew,
+				Timestamp: time.Now(),
+				Article:   a,
+			}
+			if a.Revision > 1 {
+				e.Type = models.ArticleEdited
+			}
+			events := []models.ArticleEvent{e}
+
+			subs, err := getSubscribers()
+			if err != nil {
+				return err
+			}
+			if subID != "" {
+				var filtered []models.Subscription
+				for _, sub := range subs {
+					if sub.ID == subID {
+						filtered = append(filtered, sub)
+					}
+				}
+				if len(filtered) == 0 {
+					return errors.New(subscriptionNotFound)
+				}
+				subs = filtered
+			}
+
+			var deliveries []models.Delivery
+			if replay {
+				deliveries, err = replayDeliveries(subs, events)
+			} else {
+				deliveries, err = enqueueDeliveries(subs, events)
+			}
+			if err != nil {
+				return err
+			}
+
+			// deliveries queued for quiet hours are left to the retry worker
+			var pending []models.Delivery
+			for _, d := range deliveries {
+				if d.Status == models.DeliveryPending {
+					pending = append(pending, d)
+				}
+			}
+			return printJSON(processDeliveries(pending, subs, logrus.New()))
+		},
+	}
+	cmd.Flags().BoolVar(&replay, "replay", false, "Send again to subscribers that were already notified")
+	cmd.Flags().StringVar(&subID, "subscriber", "", "Only notify the subscription with this ID")
+	return cmd
+}
+
+// exportCommand returns the `export` command writing the stored articles as
+// JSON lines, including their markdown bodies
+func exportCommand() *cobra.Command {
+	var types, out string
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export the stored articles as JSON lines",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			articles, err := getStoredArticles(types)
+			if err != nil {
+				return err
+			}
+
+			w := os.Stdout
+			if out != "" && out != "-" {
+				f, err := os.Create(out)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+			}
+
+			enc := json.NewEncoder(w)
+			for _, a := range articles {
+				if err := enc.Encode(a); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&types, "types", "", "Comma separated article types (notice,events,patch)")
+	cmd.Flags().StringVarP(&out, "out", "o", "", "File to write to, instead of stdout")
+	return cmd
+}
+
+// getStoredArticles returns the stored articles of the comma separated types,
+// or of every type, newest first
+func getStoredArticles(types string) ([]models.Article, error) {
+	ats, err := parseArticleTypes(types)
+	if err != nil {
+		return nil, err
+	}
+	all, err := getArticlesFromDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var res []models.Article
+	for _, a := range all {
+		if len(ats) == 0 || containsType(ats, a.Type) {
+			res = append(res, a)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID > res[j].ID
+	})
+	return res, nil
+}
+
+func containsType(types []models.ArticleType, t models.ArticleType) bool {
+	for _, v := range types {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
+
+func parseArticleID(args []string) (int, error) {
+	if len(args) != 1 {
+		return 0, errors.New(articleIDErr)
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return 0, errors.New(articleIDErr)
+	}
+	return id, nil
+}
+
+func parseArticleTypes(s string) ([]models.ArticleType, error) {
+	var res []models.ArticleType
+	for _, t := range splitList(s) {
+		at, err := convertURLReqType(t)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", err.Error(), t)
+		}
+		res = append(res, at)
+	}
+	return res, nil
+}
+
+func splitList(s string) []string {
+	var res []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/guregu/dynamo"
+	"github.com/mweagle/Sparta"
+	"github.com/xeia/Kings-Raid-Crawler/crawler"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// articles are tracked for this long after publishing unless
+	// COMMENT_TRACKING_PERIOD is set
+	defaultCommentTrackingPeriod = 72 * time.Hour
+
+	// articles are tracked every 15 minutes, so an article older than two
+	// passes on its first one was published before tracking was enabled
+	commentSeedAge = 30 * time.Minute
+)
+
+// commentSource is nil unless CAFE_COMMENTS_URL is set
+var commentSource *crawler.CommentSource
+
+// trackComments is invoked on a schedule to refresh the comments and reaction
+// counts of recently published articles, notifying new staff replies
+func trackComments(w http.ResponseWriter, r *http.Request) {
+	logger, _ := r.Context().Value(sparta.ContextKeyLogger).(*logrus.Logger)
+	lambdaContext, _ := r.Context().Value(sparta.ContextKeyLambdaContext).(*sparta.LambdaContext)
+	logger.WithFields(logrus.Fields{
+		"RequestID": lambdaContext.AWSRequestID,
+	}).Info(requestReceived)
+
+	if commentSource == nil {
+		writeRespHeaderWithMsg(w, http.StatusNoContent, "")
+		return
+	}
+
+	articles, err := getArticlesFromDB()
+	if err != nil {
+		logger.Error("TrackComments Error :", err.Error())
+		writeRespHeaderWithMsg(w, http.StatusInternalServerError, dbReadErr)
+		return
+	}
+
+	now := time.Now()
+	since := now.Add(-commentTrackingPeriod())
+	var events []models.ArticleEvent
+	var trackErrs []string
+	tracked := 0
+	for _, a := range articles {
+		if articlePublishedOn(a).Before(since) {
+			continue
+		}
+
+		tracked++
+		replies, err := trackArticleComments(r.Context(), a, now)
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"ArticleID": a.ID,
+			}).Error("TrackComments Error Article :", err.Error())
+			trackErrs = append(trackErrs, err.Error())
+		}
+		for _, c := range replies {
+			events = append(events, staffReplyEvent(a, c, now))
+		}
+	}
+
+	if len(events) > 0 {
+		logger.WithFields(logrus.Fields{
+			"Count": len(events),
+		}).Info("New staff replies")
+		err = notifyEvents(events, logger)
+		if err != nil {
+			logger.Error("TrackComments Error Notify :", err.Error())
+			trackErrs = append(trackErrs, err.Error())
+		}
+	}
+
+	if len(trackErrs) > 0 {
+		writeRespHeaderWithMsg(w, http.StatusInternalServerError, strings.Join(trackErrs, "\n"))
+		return
+	}
+	writeRespHeaderWithMsg(w, http.StatusOK, fmt.Sprintf("Tracked %d article(s), %d new staff reply(ies)", tracked, len(events)))
+}
+
+// trackArticleComments stores the reaction counts and comments of the
+// article, returning the staff comments that were not seen before
+func trackArticleComments(ctx context.Context, a models.Article, now time.Time) ([]models.Comment, error) {
+	prev, err := getArticleStatsFromDB(a.ID)
+	if err != nil && err != dynamo.ErrNotFound {
+		return nil, err
+	}
+	seeding := seedsComments(prev, a, now)
+
+	stats, comments, err := commentSource.Thread(ctx, a.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var replies []models.Comment
+	for _, c := range comments {
+		c.FirstSeenOn = now
+		added, err := putCommentToDB(c)
+		if err != nil {
+			return replies, err
+		}
+		if added && c.Staff && !seeding {
+			replies = append(replies, c)
+		}
+	}
+
+	// stored once every comment is, so a failed first pass is seeded again
+	stats.UpdatedOn, stats.TrackedSince = now, prev.TrackedSince
+	if stats.TrackedSince.IsZero() {
+		stats.TrackedSince = now
+	}
+	return replies, putArticleStatsToDB(stats)
+}
+
+// seedsComments returns true if the pass only records the comments of the
+// article, so the staff comments posted before tracking was enabled are not
+// all notified at once. Articles published since are notified from their
+// first pass.
+func seedsComments(prev models.ArticleStats, a models.Article, now time.Time) bool {
+	return prev.TrackedSince.IsZero() && articlePublishedOn(a).Before(now.Add(-commentSeedAge))
+}
+
+// staffReplyEvent notifies a staff comment as the article it was posted on,
+// with the comment in place of the description
+func staffReplyEvent(a models.Article, c models.Comment, now time.Time) models.ArticleEvent {
+	a.Title = fmt.Sprintf("%s replied on %s", c.Author, a.Title)
+	a.Desc = c.Content
+	a.Body, a.BodyHTML = "", ""
+	a.Author = c.Author
+	// the reply is not an edit of the article
+	a.Revision = 1
+
+	return models.ArticleEvent{
+		ID:        fmt.Sprintf("comment-%d", c.ID),
+		Type:      models.StaffReply,
+		Timestamp: now,
+		Article:   a,
+		Comment:   &c,
+	}
+}
+
+// articlePublishedOn returns when the article was published, or first seen
+// for articles scraped from the HTML
+func articlePublishedOn(a models.Article) time.Time {
+	if a.PublishedOn != nil {
+		return *a.PublishedOn
+	}
+	return a.CreatedOn
+}
+
+func commentTrackingPeriod() time.Duration {
+	d, err := time.ParseDuration(os.Getenv(envCommentTrackingPeriod))
+	if err != nil || d <= 0 {
+		return defaultCommentTrackingPeriod
+	}
+	return d
+}
+
+func putArticleStatsToDB(s models.ArticleStats) error {
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+	table := db.Table(models.ArticleStatsTable)
+	return table.Put(s).Run()
+}
+
+// putCommentToDB stores the comment, returning true if it was not stored
+// before. Known comments only have their likes and content updated.
+func putCommentToDB(c models.Comment) (bool, error) {
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+	table := db.Table(models.CommentTable)
+
+	err := table.Put(c).If("attribute_not_exists($)", models.CommentIDCol).Run()
+	if !isConditionalCheckErr(err) {
+		return err == nil, err
+	}
+	return false, table.Update(models.CommentArticleIDCol, c.ArticleID).
+		Range(models.CommentIDCol, c.ID).
+		Set(models.CommentLikesCol, c.Likes).
+		Set(models.CommentContentCol, c.Content).
+		Run()
+}
+
+func getArticleStatsFromDB(id int) (models.ArticleStats, error) {
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+
+	var s models.ArticleStats
+	table := db.Table(models.ArticleStatsTable)
+	err := table.Get(models.ArticleStatsArticleIDCol, id).One(&s)
+	return s, err
+}
+
+func getCommentsFromDB(id int) ([]models.Comment, error) {
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+
+	var res []models.Comment
+	table := db.Table(models.CommentTable)
+	err := table.Get(models.CommentArticleIDCol, id).All(&res)
+	return res, err
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"testing"
+	"time"
+)
+
+func TestSeedsComments(t *testing.T) {
+	now := time.Date(2018, 5, 1, 9, 0, 0, 0, time.UTC)
+	fresh := now.Add(-10 * time.Minute)
+	old := now.Add(-48 * time.Hour)
+
+	tests := []struct {
+		name    string
+		prev    models.ArticleStats
+		article models.Article
+		seed    bool
+	}{
+		{"article published before tracking", models.ArticleStats{}, models.Article{PublishedOn: &old}, true},
+		{"new article on its first pass", models.ArticleStats{}, models.Article{PublishedOn: &fresh}, false},
+		{"new article scraped from the HTML", models.ArticleStats{}, models.Article{CreatedOn: fresh}, false},
+		{"stats stored by the scraper", models.ArticleStats{Views: 12}, models.Article{PublishedOn: &fresh}, false},
+		{"tracked article", models.ArticleStats{TrackedSince: old}, models.Article{PublishedOn: &old}, false},
+	}
+	for _, tt := range tests {
+		if got := seedsComments(tt.prev, tt.article, now); got != tt.seed {
+			t.Errorf("%s: seedsComments = %v, want %v", tt.name, got, tt.seed)
+		}
+	}
+}
+
+func TestStaffReplyOnFirstPass(t *testing.T) {
+	now := time.Date(2018, 5, 1, 9, 0, 0, 0, time.UTC)
+	published := now.Add(-5 * time.Minute)
+	a := models.Article{ID: 7, Type: models.NOTICE, Title: "Maintenance", PublishedOn: &published, Revision: 1}
+	c := models.Comment{ID: 3, ArticleID: a.ID, Author: "GM", Content: "Extended by an hour", Staff: true}
+
+	if seedsComments(models.ArticleStats{}, a, now) {
+		t.Fatal("the first pass over a new article only records its comments")
+	}
+	e := staffReplyEvent(a, c, now)
+	if e.Type != models.StaffReply || e.Comment == nil || e.Comment.ID != c.ID {
+		t.Errorf("event = %+v", e)
+	}
+	if e.Article.Title != "GM replied on Maintenance" || e.Article.Desc != c.Content {
+		t.Errorf("article = %q: %q", e.Article.Title, e.Article.Desc)
+	}
+}
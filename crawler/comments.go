@@ -0,0 +1,79 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"strings"
+	"time"
+)
+
+// CommentSource reads the comments and reaction counts of posts from the
+// cafe's JSON comment listing
+type CommentSource struct {
+	URLFormat string // formatted with the post ID
+	Fetcher   Fetcher
+
+	// nicknames and member IDs of official accounts, lowercased
+	staff map[string]bool
+}
+
+// NewCommentSource returns a source flagging comments from the given
+// accounts, in addition to those the cafe marks as staff
+func NewCommentSource(urlFormat string, f Fetcher, staff []string) *CommentSource {
+	s := &CommentSource{URLFormat: urlFormat, Fetcher: f, staff: make(map[string]bool)}
+	for _, account := range staff {
+		if account = strings.TrimSpace(account); account != "" {
+			s.staff[strings.ToLower(account)] = true
+		}
+	}
+	return s
+}
+
+// Thread fetches the reaction counts and the comments of a post. Replies
+// are flattened, referencing the comment they reply to.
+func (s *CommentSource) Thread(ctx context.Context, id int) (models.ArticleStats, []models.Comment, error) {
+	stats := models.ArticleStats{ArticleID: id}
+	u := fmt.Sprintf(s.URLFormat, id)
+	page, err := s.Fetcher.Fetch(ctx, u, Validators{})
+	if err != nil {
+		return stats, nil, err
+	}
+
+	var listing models.CafeCommentListing
+	err = json.Unmarshal(page.Body, &listing)
+	if err != nil {
+		return stats, nil, &LayoutError{URL: u, Problems: []string{"invalid comment listing: " + err.Error()}, HTML: page.Body}
+	}
+
+	stats.Views = listing.Post.ViewCount
+	stats.Likes = listing.Post.LikeCount
+	stats.Comments = listing.Post.CommentCount
+	return stats, s.flatten(id, 0, listing.Comments, nil), nil
+}
+
+func (s *CommentSource) flatten(articleID int, parentID int, cs []models.CafeComment, res []models.Comment) []models.Comment {
+	for _, c := range cs {
+		comment := models.Comment{
+			ArticleID: articleID,
+			ID:        c.ID,
+			ParentID:  parentID,
+			Author:    c.Author.Nickname,
+			AuthorID:  c.Author.MemberID.String(),
+			Content:   strings.TrimSpace(c.Content),
+			Likes:     c.LikeCount,
+			Staff:     c.Author.Staff || s.isStaff(c.Author.Nickname, c.Author.MemberID.String()),
+		}
+		if c.CreatedAt > 0 {
+			comment.CreatedOn = time.Unix(0, c.CreatedAt*int64(time.Millisecond)).UTC()
+		}
+		res = append(res, comment)
+		res = s.flatten(articleID, c.ID, c.Replies, res)
+	}
+	return res
+}
+
+func (s *CommentSource) isStaff(nickname string, memberID string) bool {
+	return s.staff[strings.ToLower(nickname)] || (memberID != "" && s.staff[strings.ToLower(memberID)])
+}
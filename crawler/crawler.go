@@ -1,13 +1,15 @@
 package crawler
 
 import (
+	"bytes"
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
+	"fmt"
 	"github.com/PuerkitoBio/goquery"
 	"github.com/Sirupsen/logrus"
 	"github.com/xeia/Kings-Raid-Crawler/models"
 	"io"
-	"regexp"
 	"strconv"
 	"strings"
 )
@@ -15,6 +17,7 @@ import (
 // PLUG cafe links
 const (
 	CafeBase            = "https://www.plug.game/kingsraid-en"
+	Region              = "en"
 	ShowNoticeFormat    = noticesUrl + "/posts/%d"
 	ShowEventFormat     = eventsUrl + "/posts/%d"
 	ShowPatchNoteFormat = patchNotesUrl + "/posts/%d"
@@ -24,62 +27,117 @@ const (
 	noticesUrl    = CafeBase + "/posts?menuId=1#"
 	eventsUrl     = CafeBase + "/posts?menuId=2#"
 	patchNotesUrl = CafeBase + "/posts?menuId=9#"
-
-	contentsSelector = "#data-container"
-	articlesSelector = ".frame_plug"
 )
 
-var (
-	bgImgRegex = regexp.MustCompile(`background-image:url\((.*)\)`)
-)
+// Crawler scrapes the cafe at BaseURL, fetching pages through Fetcher and
+// extracting articles with the rules loaded from Rules. Pages are requested
+// conditionally when Cache holds their validators.
+type Crawler struct {
+	BaseURL string
+	Fetcher Fetcher
+	Rules   RuleSource
+	Cache   ValidatorCache
+}
+
+// Default is the crawler used by the package level Scrape functions
+var Default = &Crawler{
+	BaseURL: CafeBase,
+	Fetcher: NewHTTPFetcher(DefaultUserAgent, DefaultInterval),
+	Rules:   DefaultRules,
+}
+
+// Menus maps each ArticleType to the cafe menu listing it
+var Menus = map[models.ArticleType]int{
+	models.NOTICE:     1,
+	models.EVENTS:     2,
+	models.PATCHNOTES: 9,
+}
 
 // ScrapeNotices returns all notices loaded on the page into an Article slice
 func ScrapeNotices() ([]models.Article, string) {
-	return scrape(noticesUrl, models.NOTICE)
+	return mustScrape(models.NOTICE)
 }
 
 // ScrapeEvents returns all events loaded on the page into an Article slice
 func ScrapeEvents() ([]models.Article, string) {
-	return scrape(eventsUrl, models.EVENTS)
+	return mustScrape(models.EVENTS)
 }
 
 // ScrapePatchNotes returns all patch notes loaded on the page into an Article slice
 func ScrapePatchNotes() ([]models.Article, string) {
-	return scrape(patchNotesUrl, models.PATCHNOTES)
+	return mustScrape(models.PATCHNOTES)
 }
 
-func scrape(url string, typ models.ArticleType) ([]models.Article, string) {
-	doc, err := goquery.NewDocument(url)
-	if err != nil {
+func mustScrape(typ models.ArticleType) ([]models.Article, string) {
+	articles, cHash, save, err := DefaultSource.Scrape(context.Background(), typ)
+	if err == ErrNotModified {
+		return nil, ""
+	} else if err != nil {
 		logrus.Fatal(err)
 	}
+	save()
+	return articles, cHash
+}
+
+// MenuURL returns the URL of the menu listing articles of the given type
+func (c *Crawler) MenuURL(typ models.ArticleType) string {
+	return fmt.Sprintf("%s/posts?menuId=%d", c.BaseURL, Menus[typ])
+}
 
+// Scrape returns the articles listed in the menu of the given type, along
+// with the hash of the listing and the SaveFunc caching its validators
+func (c *Crawler) Scrape(ctx context.Context, typ models.ArticleType) ([]models.Article, string, SaveFunc, error) {
+	if _, ok := Menus[typ]; !ok {
+		return nil, "", nil, fmt.Errorf("unknown article type: %d", typ)
+	}
+
+	rules, err := c.Rules.Load()
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	u := c.MenuURL(typ)
+	page, err := fetchPage(ctx, c.Fetcher, c.Cache, u)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page.Body))
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	articles, cHash := parse(doc, rules, typ)
+	if problems := validate(doc, rules, articles); len(problems) > 0 {
+		return articles, cHash, nil, &LayoutError{URL: u, Problems: problems, HTML: page.Body}
+	}
+	return articles, cHash, savePage(c.Cache, u, page), nil
+}
+
+// parse extracts the articles from a menu page
+func parse(doc *goquery.Document, rules *Rules, typ models.ArticleType) ([]models.Article, string) {
 	var articles []models.Article
 
-	articleSelection := doc.Find(contentsSelector).Find(articlesSelector)
+	articleSelection := doc.Find(rules.Container).Find(rules.Item)
 	cHash := getContentsHash(articleSelection.Text())
 
 	articleSelection.Each(func(i int, s *goquery.Selection) {
-		article := models.Article{Type: typ}
+		article := models.Article{Type: typ, Region: Region}
 
-		articleId, exist := s.Attr("data-articleid")
+		articleId, exist := s.Attr(rules.IDAttr)
 		if exist {
 			article.ID = convertArticleId(articleId)
 		}
 
-		feed := s.Find("a.link_feed")
-		feedContents := feed.Find(".preview_text")
-
-		articleTitle := strings.TrimSpace(feedContents.Find("strong.tit_feed").Text())
-		article.Title = articleTitle
-
-		articleDetails := strings.TrimSpace(feedContents.Find("p.txt_feed").Text())
-		article.Desc = articleDetails
+		article.Title = strings.TrimSpace(s.Find(rules.Title).First().Text())
+		if rules.Description != "" {
+			article.Desc = strings.TrimSpace(s.Find(rules.Description).First().Text())
+		}
 
-		imgSelector, exist := feed.Find(".preview_feed").Find("div.img").Attr("style")
-		if exist {
-			articleImg := bgImgRegex.FindStringSubmatch(imgSelector)[1]
-			article.ImgURL = articleImg
+		if rules.Image != "" {
+			imgAttr, exist := s.Find(rules.Image).Attr(rules.ImageAttr)
+			if exist {
+				article.ImgURL = rules.imageURL(imgAttr)
+			}
 		}
 
 		articles = append(articles, article)
@@ -0,0 +1,18 @@
+package crawler
+
+import (
+	"flag"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files from the recorded pages")
+
+func TestFixtures(t *testing.T) {
+	diffs, err := VerifyFixtures("testdata", DefaultRules, *update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range diffs {
+		t.Error(d)
+	}
+}
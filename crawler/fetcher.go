@@ -0,0 +1,227 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	fetchTimeout = 30 * time.Second
+	// time left to the invocation to handle the scraped articles
+	deadlineMargin = 5 * time.Second
+
+	// DefaultUserAgent identifies the crawler to the cafe
+	DefaultUserAgent = "KingsRaidCrawler/1.0 (+https://github.com/xeia/Kings-Raid-Crawler)"
+	// DefaultInterval is the minimum time between two requests to the same host
+	DefaultInterval = time.Second
+)
+
+// ErrNotModified is returned when the page did not change since it was last fetched
+var ErrNotModified = errors.New("not modified")
+
+// Validators are the cache validators of a previously fetched page
+type Validators struct {
+	ETag         string
+	LastModified string
+}
+
+// Page is a fetched page along with its cache validators
+type Page struct {
+	Body       []byte
+	Validators Validators
+}
+
+// Fetcher retrieves the page at a URL. Fetchers supporting conditional
+// requests return ErrNotModified if the page still matches the validators.
+type Fetcher interface {
+	Fetch(ctx context.Context, url string, v Validators) (Page, error)
+}
+
+// ValidatorCache persists the validators of fetched pages between runs
+type ValidatorCache interface {
+	GetValidators(url string) (Validators, error)
+	PutValidators(url string, v Validators) error
+}
+
+// HTTPFetcher fetches pages from the live cafe, identifying itself with
+// UserAgent and spacing requests to the same host by Limiter
+type HTTPFetcher struct {
+	Client    *http.Client
+	UserAgent string
+	Limiter   *HostLimiter
+}
+
+// NewHTTPFetcher returns a fetcher sharing a single client
+func NewHTTPFetcher(userAgent string, interval time.Duration) *HTTPFetcher {
+	return &HTTPFetcher{
+		Client:    &http.Client{Timeout: fetchTimeout},
+		UserAgent: userAgent,
+		Limiter:   NewHostLimiter(interval),
+	}
+}
+
+// Fetch sends a conditional GET request to the URL, giving up before the
+// deadline of ctx so the caller has time left to report the failure
+func (f *HTTPFetcher) Fetch(ctx context.Context, u string, v Validators) (Page, error) {
+	var page Page
+	if dl, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, dl.Add(-deadlineMargin))
+		defer cancel()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return page, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", f.UserAgent)
+	if v.ETag != "" {
+		req.Header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		req.Header.Set("If-Modified-Since", v.LastModified)
+	}
+
+	err = f.Limiter.Wait(ctx, req.URL.Host)
+	if err != nil {
+		return page, err
+	}
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return page, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return page, ErrNotModified
+	default:
+		return page, fmt.Errorf("Response code received: %d %s", resp.StatusCode, u)
+	}
+
+	page.Body, err = ioutil.ReadAll(resp.Body)
+	page.Validators = Validators{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	return page, err
+}
+
+// HostLimiter spaces requests to the same host by at least Interval
+type HostLimiter struct {
+	Interval time.Duration
+
+	mu   sync.Mutex
+	next map[string]time.Time
+}
+
+// NewHostLimiter returns a limiter allowing one request per interval and host
+func NewHostLimiter(interval time.Duration) *HostLimiter {
+	return &HostLimiter{Interval: interval, next: make(map[string]time.Time)}
+}
+
+// Wait blocks until a request to host is allowed or ctx is done
+func (l *HostLimiter) Wait(ctx context.Context, host string) error {
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next[host]
+	if at.Before(now) {
+		at = now
+	}
+	l.next[host] = at.Add(l.Interval)
+	l.mu.Unlock()
+
+	t := time.NewTimer(at.Sub(now))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// FileFetcher serves pages recorded in Dir, allowing the crawler to run offline
+type FileFetcher struct {
+	Dir string
+}
+
+// Fetch reads the fixture recorded for the URL
+func (f FileFetcher) Fetch(ctx context.Context, u string, v Validators) (Page, error) {
+	var page Page
+	name, err := FixtureName(u)
+	if err != nil {
+		return page, err
+	}
+	page.Body, err = ioutil.ReadFile(filepath.Join(f.Dir, name))
+	return page, err
+}
+
+// RecordingFetcher saves every page fetched through Fetcher into Dir
+type RecordingFetcher struct {
+	Fetcher Fetcher
+	Dir     string
+}
+
+// Fetch fetches the whole page, ignoring the validators, and records it as a fixture
+func (f RecordingFetcher) Fetch(ctx context.Context, u string, v Validators) (Page, error) {
+	name, err := FixtureName(u)
+	if err != nil {
+		return Page{}, err
+	}
+
+	page, err := f.Fetcher.Fetch(ctx, u, Validators{})
+	if err != nil {
+		return page, err
+	}
+	return page, ioutil.WriteFile(filepath.Join(f.Dir, name), page.Body, 0644)
+}
+
+// FixtureName returns the file a menu page is recorded in, e.g. menu-1.html
+func FixtureName(u string) (string, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
+
+	menu := parsed.Query().Get("menuId")
+	if menu == "" {
+		return "", fmt.Errorf("no menuId in url: %s", u)
+	}
+	return "menu-" + menu + ".html", nil
+}
+
+// fetchPage fetches the page, conditionally if validators are cached for it
+func fetchPage(ctx context.Context, f Fetcher, cache ValidatorCache, u string) (Page, error) {
+	var v Validators
+	if cache != nil {
+		// a cache miss or error only costs a full fetch
+		v, _ = cache.GetValidators(u)
+	}
+	return f.Fetch(ctx, u, v)
+}
+
+// SaveFunc caches the validators of a scraped page. Callers run it once the
+// articles extracted from the page are stored, so that a failed store is
+// retried with a full fetch rather than answered with ErrNotModified.
+type SaveFunc func() error
+
+// savePage returns the SaveFunc caching the validators of page
+func savePage(cache ValidatorCache, u string, page Page) SaveFunc {
+	return func() error {
+		if cache == nil || (page.Validators.ETag == "" && page.Validators.LastModified == "") {
+			return nil
+		}
+		return cache.PutValidators(u, page.Validators)
+	}
+}
@@ -0,0 +1,37 @@
+package crawler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFetchParentDeadline(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), deadlineMargin+200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := NewHTTPFetcher(DefaultUserAgent, 0).Fetch(ctx, srv.URL, Validators{})
+	if err == nil {
+		t.Fatal("fetch succeeded past the deadline")
+	}
+	// the margin before the parent deadline is left to the caller
+	if elapsed := time.Since(start); elapsed > deadlineMargin {
+		t.Errorf("fetch gave up after %v, want before the %v margin of the deadline", elapsed, deadlineMargin)
+	}
+	if ctx.Err() != nil {
+		t.Error("fetch outlived the deadline of its parent context")
+	}
+}
@@ -0,0 +1,190 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FixturesDir is where recorded menu pages and their golden files are kept,
+// relative to the repository root
+const FixturesDir = "crawler/testdata"
+
+// ResolveFixturesDir looks for a relative dir in the working directory and
+// its parents, so the fixtures are found from anywhere inside the repository
+func ResolveFixturesDir(dir string) string {
+	if filepath.IsAbs(dir) {
+		return dir
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return dir
+	}
+	for {
+		p := filepath.Join(wd, dir)
+		if fi, err := os.Stat(p); err == nil && fi.IsDir() {
+			return p
+		}
+		parent := filepath.Dir(wd)
+		if parent == wd {
+			return dir
+		}
+		wd = parent
+	}
+}
+
+// Golden is the expected result of parsing a recorded menu page
+type Golden struct {
+	Hash     string          `json:"hash"`
+	Articles []GoldenArticle `json:"articles"`
+}
+
+// GoldenArticle holds the fields of an article parsed from a menu page,
+// independently of the JSON returned by the API
+type GoldenArticle struct {
+	ID     int                `json:"article_id"`
+	Type   models.ArticleType `json:"article_type"`
+	Title  string             `json:"article_title"`
+	Desc   string             `json:"article_description"`
+	ImgURL string             `json:"article_thumb_url"`
+	Region string             `json:"article_region"`
+}
+
+func newGolden(cHash string, articles []models.Article) Golden {
+	g := Golden{Hash: cHash}
+	for _, a := range articles {
+		g.Articles = append(g.Articles, GoldenArticle{
+			ID:     a.ID,
+			Type:   a.Type,
+			Title:  a.Title,
+			Desc:   a.Desc,
+			ImgURL: a.ImgURL,
+			Region: a.Region,
+		})
+	}
+	return g
+}
+
+// RecordFixtures fetches every menu from the live cafe into dir and
+// regenerates the golden files from the recorded pages
+func RecordFixtures(dir string, rules RuleSource) error {
+	c := &Crawler{
+		BaseURL: CafeBase,
+		Fetcher: RecordingFetcher{Fetcher: NewHTTPFetcher(DefaultUserAgent, DefaultInterval), Dir: dir},
+		Rules:   rules,
+	}
+
+	for typ := models.NOTICE; typ <= models.PATCHNOTES; typ++ {
+		articles, cHash, _, err := c.Scrape(context.Background(), typ)
+		if err != nil {
+			return err
+		}
+
+		err = writeGolden(dir, c.MenuURL(typ), newGolden(cHash, articles))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyFixtures parses the recorded menu pages in dir and describes every
+// layout problem and difference from the golden files. With update set, the
+// golden files are rewritten instead, e.g. after an intended change to the parser.
+func VerifyFixtures(dir string, rules RuleSource, update bool) ([]string, error) {
+	c := &Crawler{BaseURL: CafeBase, Fetcher: FileFetcher{Dir: dir}, Rules: rules}
+
+	var diffs []string
+	for typ := models.NOTICE; typ <= models.PATCHNOTES; typ++ {
+		u := c.MenuURL(typ)
+		articles, cHash, _, err := c.Scrape(context.Background(), typ)
+		if lerr, ok := err.(*LayoutError); ok {
+			for _, p := range lerr.Problems {
+				diffs = append(diffs, fmt.Sprintf("%s: %s", goldenName(u), p))
+			}
+		} else if err != nil {
+			return nil, err
+		}
+		got := newGolden(cHash, articles)
+
+		if update {
+			err = writeGolden(dir, u, got)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		want, err := readGolden(dir, u)
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, diffGolden(goldenName(u), got, want)...)
+	}
+	return diffs, nil
+}
+
+// diffGolden compares the parsed articles field by field
+func diffGolden(name string, got Golden, want Golden) []string {
+	var diffs []string
+	if got.Hash != want.Hash {
+		diffs = append(diffs, fmt.Sprintf("%s: hash: got %s, want %s", name, got.Hash, want.Hash))
+	}
+	if len(got.Articles) != len(want.Articles) {
+		diffs = append(diffs, fmt.Sprintf("%s: got %d articles, want %d", name, len(got.Articles), len(want.Articles)))
+	}
+
+	for i := 0; i < len(got.Articles) && i < len(want.Articles); i++ {
+		g, w := got.Articles[i], want.Articles[i]
+		fields := []struct {
+			name      string
+			got, want interface{}
+		}{
+			{"id", g.ID, w.ID},
+			{"type", g.Type, w.Type},
+			{"title", g.Title, w.Title},
+			{"description", g.Desc, w.Desc},
+			{"thumbnail", g.ImgURL, w.ImgURL},
+			{"region", g.Region, w.Region},
+		}
+		for _, f := range fields {
+			if f.got != f.want {
+				diffs = append(diffs, fmt.Sprintf("%s: article %d %s: got %#v, want %#v", name, i, f.name, f.got, f.want))
+			}
+		}
+	}
+	return diffs
+}
+
+func goldenName(u string) string {
+	name, _ := FixtureName(u)
+	return strings.TrimSuffix(name, ".html") + ".golden.json"
+}
+
+func readGolden(dir string, u string) (Golden, error) {
+	var g Golden
+	b, err := ioutil.ReadFile(filepath.Join(dir, goldenName(u)))
+	if err != nil {
+		return g, err
+	}
+	err = json.Unmarshal(b, &g)
+	return g, err
+}
+
+func writeGolden(dir string, u string, g Golden) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(g)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, goldenName(u)), buf.Bytes(), 0644)
+}
@@ -0,0 +1,368 @@
+package crawler
+
+import (
+	"fmt"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"regexp"
+	"strings"
+)
+
+// Flavor selects the markup an article body is converted to
+type Flavor int
+
+// Enum for Flavor
+const (
+	GitHubMarkdown   Flavor = iota // stored on the article
+	DiscordMarkdown                // no headings, tables or inline images
+	SlackMrkdwn                    // slack's own syntax, escaped as HTML entities
+	TelegramMarkdown               // telegram's MarkdownV2
+	PlainText
+)
+
+var (
+	whitespace  = regexp.MustCompile(`\s+`)
+	blankLines  = regexp.MustCompile(`\n{3,}`)
+	markdownEsc = strings.NewReplacer(
+		`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`,
+		`<`, `\<`, `>`, `\>`, `|`, `\|`, `~`, `\~`, `#`, `\#`,
+	)
+	slackEsc    = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	telegramEsc = strings.NewReplacer(
+		`\`, `\\`, `_`, `\_`, `*`, `\*`, `[`, `\[`, `]`, `\]`, `(`, `\(`, `)`, `\)`,
+		`~`, `\~`, "`", "\\`", `>`, `\>`, `#`, `\#`, `+`, `\+`, `-`, `\-`, `=`, `\=`,
+		`|`, `\|`, `{`, `\{`, `}`, `\}`, `.`, `\.`, `!`, `\!`,
+	)
+	telegramURLEsc  = strings.NewReplacer(`\`, `\\`, `)`, `\)`)
+	telegramCodeEsc = strings.NewReplacer(`\`, `\\`, "`", "\\`")
+)
+
+// Markdown converts an HTML fragment, such as an article body, to the flavor
+func Markdown(fragment string, f Flavor) (string, error) {
+	nodes, err := html.ParseFragment(strings.NewReader(fragment), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "div",
+		DataAtom: atom.Div,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	c := converter{flavor: f}
+	var sb strings.Builder
+	for _, n := range nodes {
+		sb.WriteString(c.node(n))
+	}
+	return tidy(sb.String()), nil
+}
+
+// Escape escapes text so it is displayed verbatim in the flavor
+func Escape(s string, f Flavor) string {
+	switch f {
+	case GitHubMarkdown, DiscordMarkdown:
+		return markdownEsc.Replace(s)
+	case SlackMrkdwn:
+		return slackEsc.Replace(s)
+	case TelegramMarkdown:
+		return telegramEsc.Replace(s)
+	}
+	return s
+}
+
+type converter struct {
+	flavor Flavor
+	pre    bool
+}
+
+func (c converter) children(n *html.Node) string {
+	var sb strings.Builder
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		sb.WriteString(c.node(ch))
+	}
+	return sb.String()
+}
+
+// inline converts the children of n to a single line
+func (c converter) inline(n *html.Node) string {
+	return strings.TrimSpace(whitespace.ReplaceAllString(c.children(n), " "))
+}
+
+func (c converter) node(n *html.Node) string {
+	switch n.Type {
+	case html.TextNode:
+		if c.pre {
+			return n.Data
+		}
+		return c.escape(whitespace.ReplaceAllString(n.Data, " "))
+	case html.ElementNode:
+	default:
+		return c.children(n)
+	}
+
+	switch n.DataAtom {
+	case atom.Script, atom.Style, atom.Noscript, atom.Head, atom.Template:
+		return ""
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		return block(c.heading(int(n.Data[1]-'0'), c.inline(n)))
+	case atom.P, atom.Div, atom.Section, atom.Article, atom.Header, atom.Footer, atom.Figure, atom.Figcaption:
+		return block(c.children(n))
+	case atom.Br:
+		return "\n"
+	case atom.Hr:
+		if c.flavor == GitHubMarkdown {
+			return block("---")
+		}
+		return block("──────────")
+	case atom.Strong, atom.B:
+		return c.wrap(c.inline(n), map[Flavor]string{GitHubMarkdown: "**", DiscordMarkdown: "**", SlackMrkdwn: "*", TelegramMarkdown: "*"})
+	case atom.Em, atom.I:
+		return c.wrap(c.inline(n), map[Flavor]string{GitHubMarkdown: "_", DiscordMarkdown: "_", SlackMrkdwn: "_", TelegramMarkdown: "_"})
+	case atom.S, atom.Del, atom.Strike:
+		return c.wrap(c.inline(n), map[Flavor]string{GitHubMarkdown: "~~", DiscordMarkdown: "~~", SlackMrkdwn: "~", TelegramMarkdown: "~"})
+	case atom.Code:
+		if c.pre {
+			return c.children(n)
+		}
+		// code is shown verbatim, only the characters the flavor reserves are escaped
+		code := textContent(n)
+		switch c.flavor {
+		case SlackMrkdwn:
+			code = slackEsc.Replace(code)
+		case TelegramMarkdown:
+			code = telegramCodeEsc.Replace(code)
+		}
+		return c.wrap(code, map[Flavor]string{GitHubMarkdown: "`", DiscordMarkdown: "`", SlackMrkdwn: "`", TelegramMarkdown: "`"})
+	case atom.Pre:
+		code := strings.Trim(converter{flavor: c.flavor, pre: true}.children(n), "\n")
+		switch c.flavor {
+		case PlainText:
+			return block(code)
+		case SlackMrkdwn:
+			code = slackEsc.Replace(code)
+		case TelegramMarkdown:
+			code = telegramCodeEsc.Replace(code)
+		}
+		return block("```\n" + code + "\n```")
+	case atom.A:
+		return c.link(c.inline(n), attr(n, "href"))
+	case atom.Img:
+		return c.image(attr(n, "alt"), attr(n, "src"))
+	case atom.Ul, atom.Ol:
+		return block(c.list(n))
+	case atom.Blockquote:
+		return block(c.quote(tidy(c.children(n))))
+	case atom.Table:
+		return block(c.table(n))
+	}
+	return c.children(n)
+}
+
+func (c converter) escape(s string) string {
+	return Escape(s, c.flavor)
+}
+
+func (c converter) heading(level int, text string) string {
+	switch c.flavor {
+	case GitHubMarkdown:
+		return strings.Repeat("#", level) + " " + text
+	case DiscordMarkdown:
+		return "**" + text + "**"
+	case SlackMrkdwn, TelegramMarkdown:
+		return "*" + text + "*"
+	}
+	return text
+}
+
+// wrap surrounds text with the flavor's delimiter, if it has one
+func (c converter) wrap(text string, delims map[Flavor]string) string {
+	d, ok := delims[c.flavor]
+	if !ok || text == "" {
+		return text
+	}
+	return d + text + d
+}
+
+func (c converter) link(text string, href string) string {
+	if href == "" {
+		return text
+	}
+	if text == "" {
+		text = c.escape(href)
+	}
+
+	switch c.flavor {
+	case GitHubMarkdown, DiscordMarkdown:
+		return fmt.Sprintf("[%s](<%s>)", text, href)
+	case SlackMrkdwn:
+		return fmt.Sprintf("<%s|%s>", href, text)
+	case TelegramMarkdown:
+		return fmt.Sprintf("[%s](%s)", text, telegramURLEsc.Replace(href))
+	}
+	if text == href {
+		return text
+	}
+	return text + " (" + href + ")"
+}
+
+func (c converter) image(alt string, src string) string {
+	if src == "" {
+		return ""
+	}
+	if c.flavor == GitHubMarkdown {
+		return fmt.Sprintf("![%s](<%s>)", c.escape(alt), src)
+	}
+	if alt == "" {
+		alt = "image"
+	}
+	if c.flavor == PlainText {
+		return "[" + alt + "]"
+	}
+	// the other flavors cannot show images inline
+	return c.link(c.escape(alt), src)
+}
+
+func (c converter) list(n *html.Node) string {
+	var items []string
+	i := 1
+	for li := n.FirstChild; li != nil; li = li.NextSibling {
+		if li.Type != html.ElementNode || li.DataAtom != atom.Li {
+			continue
+		}
+
+		marker := "- "
+		if n.DataAtom == atom.Ol {
+			marker = fmt.Sprintf("%d. ", i)
+			if c.flavor == TelegramMarkdown {
+				marker = fmt.Sprintf("%d\\. ", i)
+			}
+		} else if c.flavor == SlackMrkdwn || c.flavor == TelegramMarkdown || c.flavor == PlainText {
+			marker = "• "
+		}
+		i++
+
+		// nested lists and paragraphs are indented under the marker, keeping the list tight
+		lines := strings.Split(strings.Replace(tidy(c.children(li)), "\n\n", "\n", -1), "\n")
+		indent := strings.Repeat(" ", len([]rune(marker)))
+		for j := range lines {
+			if j == 0 {
+				lines[j] = marker + lines[j]
+			} else if lines[j] != "" {
+				lines[j] = indent + lines[j]
+			}
+		}
+		items = append(items, strings.Join(lines, "\n"))
+	}
+	return strings.Join(items, "\n")
+}
+
+func (c converter) quote(text string) string {
+	if c.flavor == PlainText {
+		return text
+	}
+
+	lines := strings.Split(text, "\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight("> "+lines[i], " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// table renders a GFM table, or a line per row in flavors without tables
+func (c converter) table(n *html.Node) string {
+	var rows [][]string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+			if ch.Type != html.ElementNode {
+				continue
+			}
+			if ch.DataAtom != atom.Tr {
+				walk(ch)
+				continue
+			}
+
+			var row []string
+			for cell := ch.FirstChild; cell != nil; cell = cell.NextSibling {
+				if cell.Type == html.ElementNode && (cell.DataAtom == atom.Td || cell.DataAtom == atom.Th) {
+					row = append(row, c.inline(cell))
+				}
+			}
+			if len(row) > 0 {
+				rows = append(rows, row)
+			}
+		}
+	}
+	walk(n)
+	if len(rows) == 0 {
+		return ""
+	}
+
+	sep := " | "
+	if c.flavor == TelegramMarkdown {
+		sep = ` \| `
+	}
+	if c.flavor != GitHubMarkdown {
+		lines := make([]string, len(rows))
+		for i, row := range rows {
+			lines[i] = strings.Join(row, sep)
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	cols := 0
+	for _, row := range rows {
+		if len(row) > cols {
+			cols = len(row)
+		}
+	}
+	lines := make([]string, 0, len(rows)+1)
+	for i, row := range rows {
+		for len(row) < cols {
+			row = append(row, "")
+		}
+		lines = append(lines, "| "+strings.Join(row, " | ")+" |")
+		if i == 0 {
+			lines = append(lines, "|"+strings.Repeat(" --- |", cols))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// block separates s from the surrounding blocks by blank lines
+func block(s string) string {
+	s = strings.Trim(s, " \n")
+	if s == "" {
+		return ""
+	}
+	return "\n\n" + s + "\n\n"
+}
+
+// tidy trims trailing spaces and collapses blank lines
+func tidy(s string) string {
+	lines := strings.Split(s, "\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], " ")
+	}
+	s = strings.Join(lines, "\n")
+	return strings.Trim(blankLines.ReplaceAllString(s, "\n\n"), "\n ")
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return strings.TrimSpace(a.Val)
+		}
+	}
+	return ""
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var sb strings.Builder
+	for ch := n.FirstChild; ch != nil; ch = ch.NextSibling {
+		sb.WriteString(textContent(ch))
+	}
+	return sb.String()
+}
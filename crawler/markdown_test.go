@@ -0,0 +1,134 @@
+package crawler
+
+import "testing"
+
+func TestMarkdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		fragment string
+		want     map[Flavor]string
+	}{
+		{
+			name:     "heading",
+			fragment: "<h2>Patch 4.2</h2>",
+			want: map[Flavor]string{
+				GitHubMarkdown:   "## Patch 4.2",
+				DiscordMarkdown:  "**Patch 4.2**",
+				SlackMrkdwn:      "*Patch 4.2*",
+				TelegramMarkdown: `*Patch 4\.2*`,
+				PlainText:        "Patch 4.2",
+			},
+		},
+		{
+			name:     "emphasis",
+			fragment: "<p><b>Bold</b> and <i>italic</i> and <s>gone</s></p>",
+			want: map[Flavor]string{
+				GitHubMarkdown:   "**Bold** and _italic_ and ~~gone~~",
+				DiscordMarkdown:  "**Bold** and _italic_ and ~~gone~~",
+				SlackMrkdwn:      "*Bold* and _italic_ and ~gone~",
+				TelegramMarkdown: "*Bold* and _italic_ and ~gone~",
+				PlainText:        "Bold and italic and gone",
+			},
+		},
+		{
+			name:     "escaping",
+			fragment: "<p>a*b &lt;c&gt; &amp;</p>",
+			want: map[Flavor]string{
+				GitHubMarkdown:   `a\*b \<c\> &`,
+				DiscordMarkdown:  `a\*b \<c\> &`,
+				SlackMrkdwn:      "a*b &lt;c&gt; &amp;",
+				TelegramMarkdown: `a\*b <c\> &`,
+				PlainText:        "a*b <c> &",
+			},
+		},
+		{
+			name:     "link",
+			fragment: `<a href="https://x.test/a_b">Read_more</a>`,
+			want: map[Flavor]string{
+				GitHubMarkdown:   `[Read\_more](<https://x.test/a_b>)`,
+				DiscordMarkdown:  `[Read\_more](<https://x.test/a_b>)`,
+				SlackMrkdwn:      "<https://x.test/a_b|Read_more>",
+				TelegramMarkdown: `[Read\_more](https://x.test/a_b)`,
+				PlainText:        "Read_more (https://x.test/a_b)",
+			},
+		},
+		{
+			name:     "image",
+			fragment: `<img src="https://x.test/i.png" alt="Hero">`,
+			want: map[Flavor]string{
+				GitHubMarkdown:   "![Hero](<https://x.test/i.png>)",
+				DiscordMarkdown:  "[Hero](<https://x.test/i.png>)",
+				SlackMrkdwn:      "<https://x.test/i.png|Hero>",
+				TelegramMarkdown: "[Hero](https://x.test/i.png)",
+				PlainText:        "[Hero]",
+			},
+		},
+		{
+			name:     "lists",
+			fragment: "<ul><li>One</li><li>Two</li></ul><ol><li>First</li></ol>",
+			want: map[Flavor]string{
+				GitHubMarkdown:   "- One\n- Two\n\n1. First",
+				DiscordMarkdown:  "- One\n- Two\n\n1. First",
+				SlackMrkdwn:      "• One\n• Two\n\n1. First",
+				TelegramMarkdown: "• One\n• Two\n\n1\\. First",
+				PlainText:        "• One\n• Two\n\n1. First",
+			},
+		},
+		{
+			name:     "code",
+			fragment: "<p>Use <code>a_b</code></p>",
+			want: map[Flavor]string{
+				GitHubMarkdown:   "Use `a_b`",
+				DiscordMarkdown:  "Use `a_b`",
+				SlackMrkdwn:      "Use `a_b`",
+				TelegramMarkdown: "Use `a_b`",
+				PlainText:        "Use a_b",
+			},
+		},
+		{
+			name:     "table",
+			fragment: "<table><tr><th>Hero</th><th>Tier</th></tr><tr><td>Kasel</td><td>S</td></tr></table>",
+			want: map[Flavor]string{
+				GitHubMarkdown:   "| Hero | Tier |\n| --- | --- |\n| Kasel | S |",
+				DiscordMarkdown:  "Hero | Tier\nKasel | S",
+				SlackMrkdwn:      "Hero | Tier\nKasel | S",
+				TelegramMarkdown: "Hero \\| Tier\nKasel \\| S",
+				PlainText:        "Hero | Tier\nKasel | S",
+			},
+		},
+		{
+			name:     "quote",
+			fragment: "<blockquote><p>Quoted</p></blockquote>",
+			want: map[Flavor]string{
+				GitHubMarkdown:   "> Quoted",
+				DiscordMarkdown:  "> Quoted",
+				SlackMrkdwn:      "> Quoted",
+				TelegramMarkdown: "> Quoted",
+				PlainText:        "Quoted",
+			},
+		},
+		{
+			name:     "scripts",
+			fragment: "<p>Shown</p><script>alert(1)</script>",
+			want: map[Flavor]string{
+				GitHubMarkdown:   "Shown",
+				DiscordMarkdown:  "Shown",
+				SlackMrkdwn:      "Shown",
+				TelegramMarkdown: "Shown",
+				PlainText:        "Shown",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		for f, want := range tt.want {
+			got, err := Markdown(tt.fragment, f)
+			if err != nil {
+				t.Fatalf("%s, flavor %d: %v", tt.name, f, err)
+			}
+			if got != want {
+				t.Errorf("%s, flavor %d: got %q, want %q", tt.name, f, got, want)
+			}
+		}
+	}
+}
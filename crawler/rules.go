@@ -0,0 +1,273 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/andybalholm/cascadia"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Rules describe how articles are extracted from a menu page. Selectors other
+// than Container and Item are relative to an item.
+type Rules struct {
+	Source      string `json:"source"`
+	Container   string `json:"container"`
+	Item        string `json:"item"`
+	IDAttr      string `json:"id_attr"`
+	Link        string `json:"link"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Image       string `json:"image"`
+	ImageAttr   string `json:"image_attr"`
+	ImageRegex  string `json:"image_regex"` // the first group holds the image URL
+	Body        string `json:"body"`        // selects the body on an article's page, optional
+
+	imageRegex *regexp.Regexp
+}
+
+// RuleSource provides the rules used by a crawler
+type RuleSource interface {
+	Load() (*Rules, error)
+}
+
+// DefaultRules match the layout of the PLUG cafe
+var DefaultRules = mustCompileRules(Rules{
+	Source:      "plug-" + Region,
+	Container:   "#data-container",
+	Item:        ".frame_plug",
+	IDAttr:      "data-articleid",
+	Link:        "a.link_feed",
+	Title:       "a.link_feed .preview_text strong.tit_feed",
+	Description: "a.link_feed .preview_text p.txt_feed",
+	Image:       "a.link_feed .preview_feed div.img",
+	ImageAttr:   "style",
+	ImageRegex:  `background-image:url\((.*)\)`,
+	Body:        ".post_content",
+})
+
+// Load returns the rules themselves
+func (r *Rules) Load() (*Rules, error) {
+	return r, nil
+}
+
+// compile validates the rules and compiles the image regex
+func (r *Rules) compile() error {
+	required := map[string]string{
+		"container": r.Container,
+		"item":      r.Item,
+		"id_attr":   r.IDAttr,
+		"link":      r.Link,
+		"title":     r.Title,
+	}
+	for name, v := range required {
+		if v == "" {
+			return fmt.Errorf("rules: %s is required", name)
+		}
+	}
+
+	for name, sel := range map[string]string{
+		"container":   r.Container,
+		"item":        r.Item,
+		"link":        r.Link,
+		"title":       r.Title,
+		"description": r.Description,
+		"image":       r.Image,
+		"body":        r.Body,
+	} {
+		if sel == "" {
+			continue
+		}
+		_, err := cascadia.Compile(sel)
+		if err != nil {
+			return fmt.Errorf("rules: invalid %s selector: %s", name, err.Error())
+		}
+	}
+
+	if r.Image != "" && r.ImageAttr == "" {
+		return fmt.Errorf("rules: image_attr is required with image")
+	}
+	if r.ImageRegex != "" {
+		re, err := regexp.Compile(r.ImageRegex)
+		if err != nil {
+			return fmt.Errorf("rules: invalid image_regex: %s", err.Error())
+		}
+		if re.NumSubexp() < 1 {
+			return fmt.Errorf("rules: image_regex must capture the image url")
+		}
+		r.imageRegex = re
+	}
+	return nil
+}
+
+// imageURL extracts the image URL from the image attribute
+func (r *Rules) imageURL(attr string) string {
+	if r.imageRegex == nil {
+		return attr
+	}
+	if m := r.imageRegex.FindStringSubmatch(attr); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+func mustCompileRules(r Rules) *Rules {
+	err := r.compile()
+	if err != nil {
+		panic(err)
+	}
+	return &r
+}
+
+// LoadRules reads and validates the rules in a JSON file
+func LoadRules(path string) (*Rules, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseRules(path, b)
+}
+
+// parseRules validates the rules read from name
+func parseRules(name string, b []byte) (*Rules, error) {
+	var r Rules
+	err := json.Unmarshal(b, &r)
+	if err != nil {
+		return nil, fmt.Errorf("rules: %s: %s", name, err.Error())
+	}
+	err = r.compile()
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// RulesFile loads rules from a JSON file, reloading them whenever the file
+// is modified. Invalid edits are logged and the last valid rules kept, or
+// DefaultRules until the file could be loaded once.
+type RulesFile struct {
+	Path string
+
+	mu      sync.Mutex
+	rules   *Rules
+	modTime time.Time
+}
+
+// NewRulesFile returns a RuleSource reading the file at path
+func NewRulesFile(path string) *RulesFile {
+	return &RulesFile{Path: path}
+}
+
+// Load returns the rules, reloading the file if it changed since the last load
+func (f *RulesFile) Load() (*Rules, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fi, err := os.Stat(f.Path)
+	if err == nil && f.rules != nil && fi.ModTime().Equal(f.modTime) {
+		return f.rules, nil
+	}
+
+	var rules *Rules
+	if err == nil {
+		rules, err = LoadRules(f.Path)
+	}
+	if err != nil {
+		if f.rules == nil {
+			logrus.WithFields(logrus.Fields{
+				"Path": f.Path,
+			}).Warn("Using default rules :", err.Error())
+			return DefaultRules, nil
+		}
+		logrus.WithFields(logrus.Fields{
+			"Path": f.Path,
+		}).Warn("Keeping previous rules :", err.Error())
+		if fi != nil {
+			// wait for the next edit instead of reloading on every scrape
+			f.modTime = fi.ModTime()
+		}
+		return f.rules, nil
+	}
+
+	if f.rules != nil {
+		logrus.WithFields(logrus.Fields{
+			"Path":   f.Path,
+			"Source": rules.Source,
+		}).Info("Rules reloaded")
+	}
+	f.rules, f.modTime = rules, fi.ModTime()
+	return rules, nil
+}
+
+// DefaultRulesTTL is how long rules loaded by a RulesObject are used before
+// they are fetched again
+const DefaultRulesTTL = 5 * time.Minute
+
+// rulesObjectTimeout bounds each fetch of a RulesObject
+const rulesObjectTimeout = 10 * time.Second
+
+// RulesObject loads rules from a remote object, e.g. in S3, fetching them
+// again once TTL has passed. Failed fetches and invalid rules are logged and
+// the last valid rules kept, or DefaultRules until a fetch succeeded once.
+type RulesObject struct {
+	Name string
+	Get  func(ctx context.Context) ([]byte, error)
+	TTL  time.Duration
+
+	mu       sync.Mutex
+	rules    *Rules
+	loadedAt time.Time
+}
+
+// NewRulesObject returns a RuleSource fetching the rules named name with get
+func NewRulesObject(name string, get func(ctx context.Context) ([]byte, error)) *RulesObject {
+	return &RulesObject{Name: name, Get: get, TTL: DefaultRulesTTL}
+}
+
+// Load returns the rules, fetching them again if they are older than TTL
+func (o *RulesObject) Load() (*Rules, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := time.Now()
+	if !o.loadedAt.IsZero() && now.Sub(o.loadedAt) < o.TTL {
+		return o.current(), nil
+	}
+	// failures wait for the TTL too, instead of fetching on every scrape
+	o.loadedAt = now
+
+	ctx, cancel := context.WithTimeout(context.Background(), rulesObjectTimeout)
+	defer cancel()
+	b, err := o.Get(ctx)
+	var rules *Rules
+	if err == nil {
+		rules, err = parseRules(o.Name, b)
+	}
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"Rules": o.Name,
+		}).Warn("Keeping previous rules :", err.Error())
+		return o.current(), nil
+	}
+
+	if o.rules != nil && o.rules.Source != rules.Source {
+		logrus.WithFields(logrus.Fields{
+			"Rules":  o.Name,
+			"Source": rules.Source,
+		}).Info("Rules reloaded")
+	}
+	o.rules = rules
+	return rules, nil
+}
+
+func (o *RulesObject) current() *Rules {
+	if o.rules == nil {
+		return DefaultRules
+	}
+	return o.rules
+}
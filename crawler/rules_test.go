@@ -0,0 +1,49 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestRulesFileFallsBackToDefaults(t *testing.T) {
+	f := NewRulesFile(filepath.Join(t.TempDir(), "missing.json"))
+	rules, err := f.Load()
+	if err != nil || rules != DefaultRules {
+		t.Fatalf("got %v, %v, want the default rules", rules, err)
+	}
+}
+
+func TestRulesObject(t *testing.T) {
+	b, err := ioutil.ReadFile(filepath.Join("sources", "plug-en.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetches := 0
+	get := func(ctx context.Context) ([]byte, error) {
+		fetches++
+		if fetches == 1 {
+			return nil, errors.New("access denied")
+		}
+		return b, nil
+	}
+	o := NewRulesObject("s3://bucket/plug-en.json", get)
+
+	rules, err := o.Load()
+	if err != nil || rules != DefaultRules {
+		t.Fatalf("got %v, %v, want the default rules after a failed fetch", rules, err)
+	}
+	o.Load()
+	if fetches != 1 {
+		t.Fatalf("fetched %d times within the TTL, want 1", fetches)
+	}
+
+	o.TTL = 0
+	rules, err = o.Load()
+	if err != nil || rules == DefaultRules || rules.Source != "plug-en" {
+		t.Fatalf("got %v, %v, want the fetched rules", rules, err)
+	}
+}
@@ -0,0 +1,118 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"net/url"
+	"strings"
+)
+
+// elements removed from snapshots, as they run code or load external resources
+const unsafeElements = "script, noscript, iframe, frame, frameset, object, embed, applet, link, base, meta[http-equiv]"
+
+// ArticlePageURL returns the server rendered page of an article. The links
+// shown to users route to the article in the browser, after the fragment.
+func (c *Crawler) ArticlePageURL(id int) string {
+	return fmt.Sprintf("%s/posts/%d", c.BaseURL, id)
+}
+
+// ArticlePage fetches the page of an article
+func (c *Crawler) ArticlePage(ctx context.Context, id int) ([]byte, error) {
+	page, err := c.Fetcher.Fetch(ctx, c.ArticlePageURL(id), Validators{})
+	return page.Body, err
+}
+
+// Sanitize returns a self-contained copy of a page for archival. Scripts,
+// frames and event handlers are removed and links are made absolute. Images
+// are replaced by the URL returned by image, e.g. a mirrored copy.
+func Sanitize(page []byte, pageURL string, image func(src string) string) ([]byte, error) {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page))
+	if err != nil {
+		return nil, err
+	}
+
+	sanitize(doc.Selection, base, image)
+	doc.Find("head").PrependHtml(`<meta charset="utf-8">`)
+
+	h, err := doc.Html()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(h), nil
+}
+
+// ArticleBody returns the sanitized HTML of the article's body, selected by
+// the body rule. Images are replaced by the URL returned by image, if any.
+func (c *Crawler) ArticleBody(ctx context.Context, id int, image func(src string) string) (string, error) {
+	rules, err := c.Rules.Load()
+	if err != nil {
+		return "", err
+	}
+	if rules.Body == "" {
+		return "", nil
+	}
+
+	page, err := c.ArticlePage(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	pageURL := c.ArticlePageURL(id)
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return "", err
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page))
+	if err != nil {
+		return "", err
+	}
+
+	body := doc.Find(rules.Body).First()
+	if body.Length() == 0 {
+		return "", fmt.Errorf("no body matching %q in %s", rules.Body, pageURL)
+	}
+	sanitize(body, base, image)
+	h, err := body.Html()
+	return strings.TrimSpace(h), err
+}
+
+// sanitize removes the unsafe elements and attributes below s
+func sanitize(s *goquery.Selection, base *url.URL, image func(string) string) {
+	s.Find(unsafeElements).Remove()
+	s.Find("*").AddSelection(s).Each(func(_ int, el *goquery.Selection) {
+		for _, n := range el.Nodes {
+			n.Attr = sanitizeAttrs(n, base, image)
+		}
+	})
+}
+
+// sanitizeAttrs drops event handlers and script URLs, resolving the remaining URLs
+func sanitizeAttrs(n *html.Node, base *url.URL, image func(string) string) []html.Attribute {
+	attrs := n.Attr[:0]
+	for _, a := range n.Attr {
+		key := strings.ToLower(a.Key)
+		if strings.HasPrefix(key, "on") || key == "srcset" {
+			continue
+		}
+
+		switch key {
+		case "href", "src", "action", "poster", "data-src":
+			u, err := base.Parse(strings.TrimSpace(a.Val))
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "mailto") {
+				continue
+			}
+			a.Val = u.String()
+			if n.Data == "img" && (key == "src" || key == "data-src") && image != nil {
+				a.Val = image(a.Val)
+			}
+		}
+		attrs = append(attrs, a)
+	}
+	return attrs
+}
@@ -0,0 +1,107 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Source returns the articles listed in a category of the cafe, along with
+// a hash of the listing used to detect changes and the SaveFunc caching the
+// validators of the fetched page
+type Source interface {
+	Scrape(ctx context.Context, typ models.ArticleType) ([]models.Article, string, SaveFunc, error)
+}
+
+// DefaultSource is the source used by the package level Scrape functions
+var DefaultSource Source = Default
+
+// FallbackSource scrapes the first of Sources succeeding
+type FallbackSource struct {
+	Sources []Source
+}
+
+// Scrape tries each source in order, returning the error of the last one if
+// all fail. A listing that was not modified is not fetched from the others.
+func (f FallbackSource) Scrape(ctx context.Context, typ models.ArticleType) ([]models.Article, string, SaveFunc, error) {
+	var err error
+	for i, s := range f.Sources {
+		var articles []models.Article
+		var cHash string
+		var save SaveFunc
+		articles, cHash, save, err = s.Scrape(ctx, typ)
+		if err == nil || err == ErrNotModified {
+			return articles, cHash, save, err
+		}
+
+		if i < len(f.Sources)-1 {
+			logrus.WithFields(logrus.Fields{
+				"ArticleType": typ.String(),
+			}).Warn("Source failed, falling back :", err.Error())
+		}
+	}
+	return nil, "", nil, err
+}
+
+// JSONSource reads articles from the cafe's JSON post listing, which
+// includes fields missing from the HTML such as views, author and
+// publication time
+type JSONSource struct {
+	URLFormat string // formatted with the menu ID
+	Fetcher   Fetcher
+	Cache     ValidatorCache
+}
+
+// Scrape fetches the listing of the given type. Listings that do not match
+// the expected shape are returned as a LayoutError.
+func (s *JSONSource) Scrape(ctx context.Context, typ models.ArticleType) ([]models.Article, string, SaveFunc, error) {
+	menu, ok := Menus[typ]
+	if !ok {
+		return nil, "", nil, fmt.Errorf("unknown article type: %d", typ)
+	}
+
+	u := fmt.Sprintf(s.URLFormat, menu)
+	page, err := fetchPage(ctx, s.Fetcher, s.Cache, u)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	var listing models.CafePostListing
+	err = json.Unmarshal(page.Body, &listing)
+	if err != nil {
+		return nil, "", nil, &LayoutError{URL: u, Problems: []string{"invalid listing: " + err.Error()}, HTML: page.Body}
+	}
+
+	var articles []models.Article
+	var contents []string
+	for _, p := range listing.Posts {
+		a := models.Article{
+			ID:     p.ID,
+			Type:   typ,
+			Title:  strings.TrimSpace(p.Title),
+			Desc:   strings.TrimSpace(p.Summary),
+			ImgURL: p.ThumbnailURL,
+			Region: Region,
+			Author: p.Author.Nickname,
+			Views:  p.ViewCount,
+		}
+		if p.CreatedAt > 0 {
+			published := time.Unix(0, p.CreatedAt*int64(time.Millisecond)).UTC()
+			a.PublishedOn = &published
+		}
+		articles = append(articles, a)
+
+		// views change constantly, so they are left out of the hash
+		contents = append(contents, strconv.Itoa(a.ID), a.Title, a.Desc)
+	}
+
+	if problems := validateArticles(articles); len(problems) > 0 {
+		return articles, "", nil, &LayoutError{URL: u, Problems: problems, HTML: page.Body}
+	}
+	return articles, getContentsHash(strings.Join(contents, "\n")), savePage(s.Cache, u, page), nil
+}
@@ -0,0 +1,82 @@
+package crawler
+
+import (
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"strings"
+)
+
+// article IDs outside this range are assumed to be parsed from the wrong attribute
+const (
+	minArticleID = 1
+	maxArticleID = 1000000000
+)
+
+// LayoutError is returned when a page no longer matches the layout expected
+// by the crawler. HTML holds the offending page.
+type LayoutError struct {
+	URL      string
+	Problems []string
+	HTML     []byte
+}
+
+func (e *LayoutError) Error() string {
+	return fmt.Sprintf("unexpected layout at %s: %s", e.URL, strings.Join(e.Problems, "; "))
+}
+
+// validate checks that the expected selectors are present and that the
+// extracted articles look sane, returning the problems found
+func validate(doc *goquery.Document, rules *Rules, articles []models.Article) []string {
+	contents := doc.Find(rules.Container)
+	if contents.Length() == 0 {
+		return []string{"missing " + rules.Container}
+	}
+	items := contents.Find(rules.Item)
+	if items.Length() == 0 {
+		return []string{fmt.Sprintf("no %s found in %s", rules.Item, rules.Container)}
+	}
+
+	var problems []string
+	missing := func(sel string) {
+		n := items.FilterFunction(func(i int, s *goquery.Selection) bool {
+			return s.Find(sel).Length() == 0
+		}).Length()
+		if n > 0 {
+			problems = append(problems, fmt.Sprintf("%d of %d articles without %s", n, items.Length(), sel))
+		}
+	}
+	missing(rules.Link)
+	missing(rules.Title)
+
+	return append(problems, validateArticles(articles)...)
+}
+
+// validateArticles checks that the extracted articles look sane
+func validateArticles(articles []models.Article) []string {
+	if len(articles) == 0 {
+		return []string{"no articles found"}
+	}
+
+	var problems []string
+	emptyTitles, badIDs := 0, 0
+	seen := make(map[int]bool)
+	for _, a := range articles {
+		if a.Title == "" {
+			emptyTitles++
+		}
+		if a.ID < minArticleID || a.ID > maxArticleID {
+			badIDs++
+		} else if seen[a.ID] {
+			problems = append(problems, fmt.Sprintf("duplicate article id %d", a.ID))
+		}
+		seen[a.ID] = true
+	}
+	if emptyTitles > 0 {
+		problems = append(problems, fmt.Sprintf("%d of %d articles with an empty title", emptyTitles, len(articles)))
+	}
+	if badIDs > 0 {
+		problems = append(problems, fmt.Sprintf("%d of %d articles with a missing or out of range id", badIDs, len(articles)))
+	}
+	return problems
+}
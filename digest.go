@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/guregu/dynamo"
+	"github.com/mweagle/Sparta"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	day  = 24 * time.Hour
+	week = 7 * day
+
+	// digestSlack allows for the scheduled job not running at the exact same time every day
+	digestSlack = time.Hour
+)
+
+// digestLinkEscaper escapes article titles used as the text of markdown links
+var digestLinkEscaper = strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`, "(", `\(`, ")", `\)`)
+
+// digestFunc sends a digest of the articles published in a period to a single subscriber
+type digestFunc func(sub models.Subscription, articles []models.Article, from time.Time, to time.Time) error
+
+// digesters maps a subscription kind to the function sending its digests
+var digesters = map[string]digestFunc{
+	models.SubscriptionDiscord: sendDiscordDigest,
+	models.SubscriptionEmail:   sendEmailDigest,
+}
+
+// sendDigests is invoked daily to send digests to the subscribers whose period has elapsed
+func sendDigests(w http.ResponseWriter, r *http.Request) {
+	logger, _ := r.Context().Value(sparta.ContextKeyLogger).(*logrus.Logger)
+	lambdaContext, _ := r.Context().Value(sparta.ContextKeyLambdaContext).(*sparta.LambdaContext)
+	logger.WithFields(logrus.Fields{
+		"RequestID": lambdaContext.AWSRequestID,
+	}).Info(requestReceived)
+
+	subs, err := getSubscriptionsFromDB()
+	if err != nil {
+		logger.Error("SendDigests Error Subscribers :", err.Error())
+		writeRespHeaderWithMsg(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	now := time.Now()
+	sent, failed := 0, 0
+	for _, sub := range subs {
+		from, due := digestPeriod(sub, now)
+		if !due {
+			continue
+		}
+
+		err := sendDigest(sub, from, now)
+		if err != nil {
+			failed++
+			logger.WithFields(logrus.Fields{
+				"SubscriptionID": sub.ID,
+			}).Error("SendDigests Error Send :", err.Error())
+			continue
+		}
+		sent++
+	}
+
+	logger.WithFields(logrus.Fields{
+		"Sent":   sent,
+		"Failed": failed,
+	}).Info("SendDigests Complete")
+	writeRespHeaderWithMsg(w, http.StatusOK, fmt.Sprintf("%d digest(s) sent, %d failed", sent, failed))
+}
+
+// digestPeriod returns the start of the subscriber's current digest period
+// and whether the digest is due at t
+func digestPeriod(sub models.Subscription, t time.Time) (time.Time, bool) {
+	var period time.Duration
+	switch sub.Frequency {
+	case models.FrequencyDaily:
+		period = day
+	case models.FrequencyWeekly:
+		period = week
+	default:
+		return t, false
+	}
+
+	if sub.LastDigestOn == nil {
+		return t.Add(-period), true
+	}
+	return *sub.LastDigestOn, t.Sub(*sub.LastDigestOn) >= period-digestSlack
+}
+
+// sendDigest sends the articles created between from and to matching the
+// subscriber's filters, then records the end of the period. Failed digests
+// are retried with a longer period on the next run.
+func sendDigest(sub models.Subscription, from time.Time, to time.Time) error {
+	digest, ok := digesters[subscriptionKind(sub)]
+	if !ok {
+		return fmt.Errorf("%s: %s", subscriptionKindErr, sub.Kind)
+	}
+
+	all, err := getArticlesCreatedBetweenFromDB(from, to)
+	if err != nil {
+		return err
+	}
+
+	var articles []models.Article
+	for _, a := range all {
+		if sub.Matches(a) {
+			articles = append(articles, a)
+		}
+	}
+
+	if len(articles) > 0 {
+		err = digest(sub, articles, from, to)
+		if err != nil {
+			return err
+		}
+	}
+	return updateLastDigestInDB(sub.ID, to)
+}
+
+// sendDiscordDigest posts a single message with one embed per article type
+func sendDiscordDigest(sub models.Subscription, articles []models.Article, from time.Time, to time.Time) error {
+	if !enableDiscordHook {
+		return nil
+	}
+
+	msg := buildDiscordDigest(sub, articles, from, to)
+	jsonBytes, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return sendHook(sub.WebhookURL, jsonBytes)
+}
+
+// buildDiscordDigest groups the articles by type, listing them newest first.
+// The lists share the characters discord allows across the embeds of a message.
+func buildDiscordDigest(sub models.Subscription, articles []models.Article, from time.Time, to time.Time) models.DiscordHookMessage {
+	grouped := groupArticlesByType(articles)
+
+	var embeds []models.DiscordEmbed
+	var lists [][]string
+	budget := maxDiscordEmbedsTotal
+	for _, t := range []models.ArticleType{models.NOTICE, models.EVENTS, models.PATCHNOTES} {
+		as, ok := grouped[t]
+		if !ok {
+			continue
+		}
+
+		var lines []string
+		for _, a := range as {
+			lines = append(lines, fmt.Sprintf("• [%s](%s)", digestLinkEscaper.Replace(a.Title), formatArticleURL(a.Type, a.ID)))
+		}
+		e := models.DiscordEmbed{
+			Title:     fmt.Sprintf("%s (%d)", t.String(), len(as)),
+			Color:     generateColorCode(t),
+			Timestamp: to.UTC().Format(time.RFC3339),
+		}
+		budget -= len([]rune(e.Title))
+		embeds = append(embeds, e)
+		lists = append(lists, lines)
+	}
+
+	// lists shorter than their share leave the rest to the following ones
+	for i := range embeds {
+		n := budget / (len(embeds) - i)
+		if n > maxDiscordDescription {
+			n = maxDiscordDescription
+		}
+		embeds[i].Description = truncateLines(lists[i], n)
+		budget -= len([]rune(embeds[i].Description))
+	}
+
+	name := "Daily"
+	if sub.Frequency == models.FrequencyWeekly {
+		name = "Weekly"
+	}
+	return models.DiscordHookMessage{
+		Content: fmt.Sprintf("%s digest: %d new article(s) on the PLUG cafe since %s",
+			name, len(articles), from.UTC().Format("Jan 2 15:04 MST")),
+		Embeds: embeds,
+	}
+}
+
+// groupArticlesByType groups the articles by type, sorted newest first
+func groupArticlesByType(articles []models.Article) map[models.ArticleType][]models.Article {
+	sort.Slice(articles, func(i, j int) bool {
+		return articles[i].ID > articles[j].ID
+	})
+
+	grouped := make(map[models.ArticleType][]models.Article)
+	for _, a := range articles {
+		grouped[a.Type] = append(grouped[a.Type], a)
+	}
+	return grouped
+}
+
+// truncateLines joins as many lines as fit within n characters,
+// summarising the remaining ones
+func truncateLines(lines []string, n int) string {
+	const moreReserve = 32 // room for the "…and N more" line
+
+	joined := strings.Join(lines, "\n")
+	if len([]rune(joined)) <= n {
+		return joined
+	}
+
+	var res []string
+	size := 0
+	for i, l := range lines {
+		size += len([]rune(l)) + 1
+		if size > n-moreReserve {
+			res = append(res, fmt.Sprintf("…and %d more", len(lines)-i))
+			break
+		}
+		res = append(res, l)
+	}
+	return strings.Join(res, "\n")
+}
+
+// getArticlesCreatedBetweenFromDB returns the articles first seen between from and to
+// getArticlesCreatedBetweenFromDB scans the articles created within the
+// bounds. Times are stored as RFC 3339 strings, which only compare correctly
+// in the same offset, so the bounds are converted to UTC like the stored times.
+func getArticlesCreatedBetweenFromDB(from time.Time, to time.Time) ([]models.Article, error) {
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+
+	var res []models.Article
+	table := db.Table(models.ArticleTable)
+	err := table.Scan().Filter("$ BETWEEN ? AND ?", models.ArticleCreatedOnCol, from.UTC(), to.UTC()).All(&res)
+	return res, err
+}
+
+func updateLastDigestInDB(subID string, t time.Time) error {
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+	table := db.Table(models.SubscriptionTable)
+	return table.Update(models.SubscriptionIDCol, subID).Set(models.SubscriptionDigestCol, t).Run()
+}
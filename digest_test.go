@@ -0,0 +1,68 @@
+package main
+
+import (
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildDiscordDigestLimits(t *testing.T) {
+	var articles []models.Article
+	for i := 1; i <= 300; i++ {
+		articles = append(articles, models.Article{
+			ID:    i,
+			Type:  models.ArticleType(i%3 + 1),
+			Title: strings.Repeat("x", 40) + " [maintenance] (extended)",
+		})
+	}
+
+	now := time.Date(2018, 5, 1, 9, 0, 0, 0, time.UTC)
+	msg := buildDiscordDigest(models.Subscription{Frequency: models.FrequencyDaily}, articles, now.Add(-day), now)
+	if len(msg.Embeds) != 3 {
+		t.Fatalf("got %d embeds, want 3", len(msg.Embeds))
+	}
+
+	total := 0
+	for _, e := range msg.Embeds {
+		n := len([]rune(e.Description))
+		if n > maxDiscordDescription {
+			t.Errorf("%s: description of %d characters", e.Title, n)
+		}
+		if !strings.Contains(e.Description, "more") {
+			t.Errorf("%s: truncated list is not summarised", e.Title)
+		}
+		if strings.Contains(e.Description, " [maintenance]") {
+			t.Errorf("%s: title brackets are not escaped", e.Title)
+		}
+		total += n + len([]rune(e.Title))
+	}
+	if total > maxDiscordEmbedsTotal {
+		t.Errorf("embeds total %d characters, want at most %d", total, maxDiscordEmbedsTotal)
+	}
+}
+
+func TestDigestPeriod(t *testing.T) {
+	now := time.Date(2018, 5, 8, 9, 0, 0, 0, time.UTC)
+	last := now.Add(-day + 30*time.Minute)
+	recent := now.Add(-2 * time.Hour)
+
+	tests := []struct {
+		name string
+		sub  models.Subscription
+		from time.Time
+		due  bool
+	}{
+		{"immediate", models.Subscription{}, now, false},
+		{"first daily", models.Subscription{Frequency: models.FrequencyDaily}, now.Add(-day), true},
+		{"daily within slack", models.Subscription{Frequency: models.FrequencyDaily, LastDigestOn: &last}, last, true},
+		{"daily too early", models.Subscription{Frequency: models.FrequencyDaily, LastDigestOn: &recent}, recent, false},
+		{"weekly too early", models.Subscription{Frequency: models.FrequencyWeekly, LastDigestOn: &last}, last, false},
+	}
+	for _, tt := range tests {
+		from, due := digestPeriod(tt.sub, now)
+		if !from.Equal(tt.from) || due != tt.due {
+			t.Errorf("%s: digestPeriod = %v, %v, want %v, %v", tt.name, from, due, tt.from, tt.due)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/guregu/dynamo"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const editedMarker = "Edited"
+
+// notifyDiscord posts new articles to the subscriber's discord webhook and
+// edits the previously posted message of revised articles
+func notifyDiscord(sub models.Subscription, events []models.ArticleEvent, logger *logrus.Logger) []error {
+	errs := make([]error, len(events))
+	if !enableDiscordHook {
+		return errs
+	}
+
+	var articles, replies []models.Article
+	var indexes, replyIndexes []int
+	for i, e := range events {
+		switch e.Type {
+		case models.StaffReply:
+			replies = append(replies, e.Article)
+			replyIndexes = append(replyIndexes, i)
+		case models.ArticleNew:
+			articles = append(articles, e.Article)
+			indexes = append(indexes, i)
+		case models.ArticleEdited:
+			edited, err := editDiscordArticle(sub, e.Article)
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+
+			// articles posted before their message was remembered are posted again
+			if !edited {
+				articles = append(articles, e.Article)
+				indexes = append(indexes, i)
+			}
+		}
+	}
+	for i, err := range sendDiscordArticles(sub, articles, true, logger) {
+		errs[indexes[i]] = err
+	}
+	// replies carry the ID of their article, whose message must not be replaced
+	for i, err := range sendDiscordArticles(sub, replies, false, logger) {
+		errs[replyIndexes[i]] = err
+	}
+	return errs
+}
+
+// sendDiscordArticles posts the articles to the subscriber's discord webhook,
+// remembering the message each article was posted in if remember is set. It
+// returns the result of each article, as only the articles of a failed
+// message need a retry.
+func sendDiscordArticles(sub models.Subscription, articles []models.Article, remember bool, logger *logrus.Logger) []error {
+	errs := make([]error, len(articles))
+	if len(articles) == 0 {
+		return errs
+	}
+
+	msgs, err := buildDiscordMessages(sub, articles)
+	if err != nil {
+		return failEvents(len(articles), err)
+	}
+
+	postURL, err := discordWaitURL(sub.WebhookURL)
+	if err != nil {
+		return failEvents(len(articles), err)
+	}
+
+	for _, msg := range msgs {
+		jsonBytes, err := json.Marshal(msg.Message)
+		var resp models.DiscordMessageResponse
+		if err == nil {
+			err = doHookRequest(http.MethodPost, postURL, jsonBytes, &resp)
+		}
+		if err != nil {
+			for j := msg.Start; j < msg.End; j++ {
+				errs[j] = err
+			}
+			continue
+		}
+		if !remember || resp.ID == "" {
+			continue
+		}
+
+		err = addDiscordMessagesToDB(sub.ID, resp.ID, articles[msg.Start:msg.End])
+		if err != nil {
+			// the message was sent, so only future edits are affected
+			logger.WithFields(logrus.Fields{
+				"SubscriptionID": sub.ID,
+				"MessageID":      resp.ID,
+			}).Error("Discord Error Record Message :", err.Error())
+		}
+	}
+	return errs
+}
+
+// editDiscordArticle updates the webhook message the article was posted in.
+// It returns false if no message is known for the article.
+func editDiscordArticle(sub models.Subscription, a models.Article) (bool, error) {
+	dm, err := getDiscordMessageFromDB(sub.ID, a.ID)
+	if err == dynamo.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	editedIDs := dm.EditedIDs
+	if !containsInt(editedIDs, a.ID) {
+		editedIDs = append(editedIDs, a.ID)
+	}
+
+	// rebuild every embed of the message, as an edit replaces all of them,
+	// keeping the marker of the articles edited before
+	var articles []models.Article
+	for _, id := range dm.ArticleIDs {
+		if id == a.ID {
+			a.Edited = true
+			articles = append(articles, a)
+			continue
+		}
+
+		other, err := getArticleFromDB(id)
+		if err != nil {
+			return false, err
+		}
+		other.Edited = containsInt(editedIDs, id)
+		articles = append(articles, other)
+	}
+
+	var embeds []models.DiscordEmbed
+	for _, article := range articles {
+		e, err := renderEmbed(sub.Templates, article, len(articles))
+		if err != nil {
+			return false, err
+		}
+		embeds = append(embeds, e)
+	}
+
+	editURL, err := discordEditURL(sub.WebhookURL, dm.MessageID)
+	if err != nil {
+		return false, err
+	}
+
+	jsonBytes, err := json.Marshal(models.DiscordHookMessage{Embeds: embeds})
+	if err != nil {
+		return false, err
+	}
+	err = doHookRequest(http.MethodPatch, editURL, jsonBytes, nil)
+	if err != nil {
+		return true, err
+	}
+
+	// the message was edited, so only the markers of future edits are affected
+	if err := updateDiscordMessageEditsInDB(sub.ID, dm.ArticleIDs, editedIDs); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"SubscriptionID": sub.ID,
+			"MessageID":      dm.MessageID,
+		}).Error("Discord Error Record Edit :", err.Error())
+	}
+	return true, nil
+}
+
+func containsInt(s []int, v int) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// buildMentions returns the mentions matching the articles, along with the
+// allowed mentions restricting the message to pinging only those
+func buildMentions(mentions []models.Mention, articles []models.Article) (string, *models.DiscordAllowedMentions) {
+	allowed := &models.DiscordAllowedMentions{Parse: []string{}}
+	seen := make(map[string]bool)
+	var prefix []string
+
+	for _, m := range mentions {
+		matched := false
+		for _, a := range articles {
+			if m.Matches(a) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+
+		for _, r := range m.Roles {
+			if !seen["r"+r] {
+				seen["r"+r] = true
+				allowed.Roles = append(allowed.Roles, r)
+				prefix = append(prefix, "<@&"+r+">")
+			}
+		}
+		for _, u := range m.Users {
+			if !seen["u"+u] {
+				seen["u"+u] = true
+				allowed.Users = append(allowed.Users, u)
+				prefix = append(prefix, "<@"+u+">")
+			}
+		}
+	}
+	return strings.Join(prefix, " "), allowed
+}
+
+// isSnowflake returns true if id looks like a discord ID
+func isSnowflake(id string) bool {
+	_, err := strconv.ParseUint(id, 10, 64)
+	return err == nil
+}
+
+// markEdited flags the embed of an article notified as edited
+func markEdited(embed models.DiscordEmbed, a models.Article) models.DiscordEmbed {
+	if !a.Edited {
+		return embed
+	}
+
+	if embed.Footer.Text == "" {
+		embed.Footer.Text = editedMarker
+	} else {
+		embed.Footer.Text += " (" + editedMarker + ")"
+	}
+	if !a.ModifiedOn.IsZero() {
+		embed.Timestamp = a.ModifiedOn.UTC().Format(time.RFC3339)
+	}
+	return embed
+}
+
+// discordWaitURL returns the webhook URL asking discord to return the created message
+func discordWaitURL(webhookURL string) (string, error) {
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return "", err
+	}
+
+	q := u.Query()
+	q.Set("wait", "true")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// discordEditURL returns the URL used to edit a message sent by the webhook
+func discordEditURL(webhookURL string, messageID string) (string, error) {
+	u, err := url.Parse(webhookURL)
+	if err != nil {
+		return "", err
+	}
+
+	u.Path += "/messages/" + messageID
+	return u.String(), nil
+}
+
+func addDiscordMessagesToDB(subID string, messageID string, articles []models.Article) error {
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+	table := db.Table(models.DiscordMessageTable)
+
+	var ids, editedIDs []int
+	for _, a := range articles {
+		ids = append(ids, a.ID)
+		if a.Edited {
+			editedIDs = append(editedIDs, a.ID)
+		}
+	}
+
+	for _, a := range articles {
+		err := table.Put(models.DiscordMessage{
+			SubscriptionID: subID,
+			ArticleID:      a.ID,
+			MessageID:      messageID,
+			ArticleIDs:     ids,
+			EditedIDs:      editedIDs,
+			CreatedOn:      time.Now(),
+		}).Run()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getDiscordMessageFromDB(subID string, articleID int) (models.DiscordMessage, error) {
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+
+	var dm models.DiscordMessage
+	table := db.Table(models.DiscordMessageTable)
+	err := table.Get(models.DiscordMessageSubscriptionCol, subID).
+		Range(models.DiscordMessageArticleCol, dynamo.Equal, articleID).
+		One(&dm)
+	return dm, err
+}
+
+// updateDiscordMessageEditsInDB stores the edited articles on the record of
+// every article embedded in the message
+func updateDiscordMessageEditsInDB(subID string, articleIDs []int, editedIDs []int) error {
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+	table := db.Table(models.DiscordMessageTable)
+
+	for _, id := range articleIDs {
+		err := table.Update(models.DiscordMessageSubscriptionCol, subID).
+			Range(models.DiscordMessageArticleCol, id).
+			Set(models.DiscordMessageEditedCol, editedIDs).
+			Run()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
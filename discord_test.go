@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/Sirupsen/logrus"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotifyDiscordStaffReply(t *testing.T) {
+	var posted []models.DiscordHookMessage
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg models.DiscordHookMessage
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&msg) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		posted = append(posted, msg)
+		// without a message ID nothing would be remembered either way
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	a := models.Article{ID: 7, Type: models.NOTICE, Title: "Patch notes"}
+	c := models.Comment{ID: 3, ArticleID: a.ID, Author: "GM", Content: "Fixed!", Staff: true}
+	e := staffReplyEvent(a, c, time.Now())
+
+	sub := models.Subscription{ID: "discord", WebhookURL: srv.URL}
+	errs := notifyDiscord(sub, []models.ArticleEvent{e}, logrus.New())
+	if len(errs) != 1 || errs[0] != nil {
+		t.Fatalf("errs: %v", errs)
+	}
+	if len(posted) != 1 || len(posted[0].Embeds) != 1 {
+		t.Fatalf("posted %d message(s), want 1 with the reply", len(posted))
+	}
+	if got := posted[0].Embeds[0].Title; got != "GM replied on Patch notes" {
+		t.Errorf("title: %q", got)
+	}
+}
+
+func TestSendDiscordArticlesEmbedsTotal(t *testing.T) {
+	var posted []models.DiscordHookMessage
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg models.DiscordHookMessage
+		if json.NewDecoder(r.Body).Decode(&msg) != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		posted = append(posted, msg)
+		if len(posted) == 2 {
+			http.Error(w, "failure", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	var articles []models.Article
+	for i := 1; i <= 10; i++ {
+		articles = append(articles, models.Article{ID: i, Type: models.NOTICE, Title: "Notice", Desc: strings.Repeat("d", 2000)})
+	}
+
+	sub := models.Subscription{ID: "discord", WebhookURL: srv.URL}
+	errs := sendDiscordArticles(sub, articles, false, logrus.New())
+	if len(posted) < 2 {
+		t.Fatalf("posted %d message(s), want the articles split", len(posted))
+	}
+
+	embedded := 0
+	for i, msg := range posted {
+		total := 0
+		for _, e := range msg.Embeds {
+			total += discordEmbedSize(e)
+		}
+		if total > maxDiscordEmbedsTotal {
+			t.Errorf("message %d: embeds total %d characters, want at most %d", i, total, maxDiscordEmbedsTotal)
+		}
+		// only the articles of the failed message need a retry
+		for j := embedded; j < embedded+len(msg.Embeds); j++ {
+			if (errs[j] != nil) != (i == 1) {
+				t.Errorf("article %d in message %d: error %v", j, i, errs[j])
+			}
+		}
+		embedded += len(msg.Embeds)
+	}
+	if embedded != len(articles) {
+		t.Errorf("embedded %d articles, want %d", embedded, len(articles))
+	}
+}
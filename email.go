@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/mweagle/Sparta"
+	"github.com/xeia/Kings-Raid-Crawler/crawler"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+const (
+	defaultSMTPPort = "587"
+	emailSubject    = "[King's Raid] "
+
+	unsubscribeErr     = "invalid unsubscribe link"
+	unsubscribeSuccess = "You have been unsubscribed."
+)
+
+// smtpTimeout bounds connecting to and talking with the SMTP server
+var smtpTimeout = 20 * time.Second
+
+// emailArticle is an article as rendered in an email
+type emailArticle struct {
+	Title       string
+	Description string
+	Footer      string
+	URL         string
+	ImgURL      string
+	TypeName    string
+}
+
+// emailSection is a group of articles under an optional heading
+type emailSection struct {
+	Name     string
+	Articles []emailArticle
+}
+
+// emailData is passed to the email templates
+type emailData struct {
+	Heading        string
+	Sections       []emailSection
+	UnsubscribeURL string
+}
+
+var emailHTMLTemplate = htmltemplate.Must(htmltemplate.New("email").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: Arial, sans-serif; color: #333; max-width: 600px; margin: 0 auto;">
+{{if .Heading}}<h2>{{.Heading}}</h2>{{end}}
+{{range .Sections}}
+{{if .Name}}<h3 style="border-bottom: 1px solid #ddd;">{{.Name}}</h3>{{end}}
+{{range .Articles}}
+<div style="margin-bottom: 24px;">
+<h4 style="margin-bottom: 4px;"><a href="{{.URL}}">{{.Title}}</a></h4>
+<p style="margin: 0; color: #888; font-size: 12px;">{{.TypeName}}{{if .Footer}} · {{.Footer}}{{end}}</p>
+{{if .Description}}<p>{{.Description}}</p>{{end}}
+{{if .ImgURL}}<a href="{{.URL}}"><img src="{{.ImgURL}}" alt="{{.Title}}" style="max-width: 100%;"></a>{{end}}
+</div>
+{{end}}
+{{end}}
+{{if .UnsubscribeURL}}<p style="color: #888; font-size: 12px;"><a href="{{.UnsubscribeURL}}">Unsubscribe</a></p>{{end}}
+</body>
+</html>
+`))
+
+var emailTextTemplate = texttemplate.Must(texttemplate.New("email").Parse(`{{if .Heading}}{{.Heading}}
+
+{{end}}{{range .Sections}}{{if .Name}}== {{.Name}} ==
+
+{{end}}{{range .Articles}}{{.Title}}
+{{.TypeName}}{{if .Footer}} · {{.Footer}}{{end}}
+{{if .Description}}{{.Description}}
+{{end}}{{.URL}}
+
+{{end}}{{end}}{{if .UnsubscribeURL}}Unsubscribe: {{.UnsubscribeURL}}
+{{end}}`))
+
+// notifyEmail sends new and edited articles to the subscriber's address in a single email
+func notifyEmail(sub models.Subscription, events []models.ArticleEvent, logger *logrus.Logger) []error {
+	errs := make([]error, len(events))
+	var articles []emailArticle
+	var indexes []int
+	for i, e := range events {
+		if e.Type == models.ArticleRemoved {
+			continue
+		}
+
+		a, err := newEmailArticle(sub, e.Article, len(events))
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		articles = append(articles, a)
+		indexes = append(indexes, i)
+	}
+	if len(articles) == 0 {
+		return errs
+	}
+
+	subject := fmt.Sprintf("%d new articles on the PLUG cafe", len(articles))
+	if len(articles) == 1 {
+		subject = articles[0].Title
+	}
+	err := sendEmail(sub, subject, emailData{Sections: []emailSection{{Articles: articles}}})
+	for _, idx := range indexes {
+		errs[idx] = err
+	}
+	return errs
+}
+
+// sendEmailDigest sends a single email listing the articles grouped by type
+func sendEmailDigest(sub models.Subscription, articles []models.Article, from time.Time, to time.Time) error {
+	grouped := groupArticlesByType(articles)
+
+	var sections []emailSection
+	for _, t := range []models.ArticleType{models.NOTICE, models.EVENTS, models.PATCHNOTES} {
+		as, ok := grouped[t]
+		if !ok {
+			continue
+		}
+
+		section := emailSection{Name: fmt.Sprintf("%s (%d)", t.String(), len(as))}
+		for _, a := range as {
+			ea, err := newEmailArticle(sub, a, len(articles))
+			if err != nil {
+				return err
+			}
+			section.Articles = append(section.Articles, ea)
+		}
+		sections = append(sections, section)
+	}
+
+	name := "Daily"
+	if sub.Frequency == models.FrequencyWeekly {
+		name = "Weekly"
+	}
+	subject := fmt.Sprintf("%s digest: %d new article(s)", name, len(articles))
+	heading := fmt.Sprintf("%d new article(s) on the PLUG cafe since %s", len(articles), from.UTC().Format("Jan 2 15:04 MST"))
+	return sendEmail(sub, subject, emailData{Heading: heading, Sections: sections})
+}
+
+// newEmailArticle renders the article using the subscriber's templates
+func newEmailArticle(sub models.Subscription, a models.Article, count int) (emailArticle, error) {
+	embed, err := renderEmbedFor(sub.Templates, a, count, crawler.PlainText)
+	if err != nil {
+		return emailArticle{}, err
+	}
+
+	return emailArticle{
+		Title:       embed.Title,
+		Description: embed.Description,
+		Footer:      embed.Footer.Text,
+		URL:         embed.URL,
+		ImgURL:      a.ImgURL,
+		TypeName:    a.Type.String(),
+	}, nil
+}
+
+// sendEmail renders the data and sends it through the configured SMTP server
+func sendEmail(sub models.Subscription, subject string, data emailData) error {
+	host := os.Getenv(envSMTPHost)
+	from := os.Getenv(envSMTPFrom)
+	if host == "" || from == "" {
+		return errors.New(envSMTPErr)
+	}
+	port := os.Getenv(envSMTPPort)
+	if port == "" {
+		port = defaultSMTPPort
+	}
+
+	data.UnsubscribeURL = unsubscribeURL(sub)
+	msg, err := buildEmail(from, sub.Email, emailSubject+subject, data, time.Now())
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if user := os.Getenv(envSMTPUsername); user != "" {
+		auth = smtp.PlainAuth("", user, os.Getenv(envSMTPPassword), host)
+	}
+
+	fromAddr, err := mail.ParseAddress(from)
+	if err != nil {
+		return err
+	}
+	return sendSMTP(host, port, auth, fromAddr.Address, sub.Email, msg)
+}
+
+// sendSMTP sends the message like smtp.SendMail, but bounds the whole exchange
+// by smtpTimeout so that a stalled server cannot hold up the invocation
+func sendSMTP(host string, port string, auth smtp.Auth, from string, to string, msg []byte) error {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), smtpTimeout)
+	if err != nil {
+		return err
+	}
+	err = conn.SetDeadline(time.Now().Add(smtpTimeout))
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(&tls.Config{ServerName: host})
+		if err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		err = c.Auth(auth)
+		if err != nil {
+			return err
+		}
+	}
+	err = c.Mail(from)
+	if err != nil {
+		return err
+	}
+	err = c.Rcpt(to)
+	if err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(msg)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// buildEmail returns a multipart/alternative message with a plaintext and an HTML part
+func buildEmail(from string, to string, subject string, data emailData, now time.Time) ([]byte, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	var text bytes.Buffer
+	err := emailTextTemplate.Execute(&text, data)
+	if err != nil {
+		return nil, err
+	}
+	var html bytes.Buffer
+	err = emailHTMLTemplate.Execute(&html, data)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := []struct {
+		contentType string
+		content     []byte
+	}{
+		{"text/plain; charset=utf-8", text.Bytes()},
+		{"text/html; charset=utf-8", html.Bytes()},
+	}
+	for _, p := range parts {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		qw := quotedprintable.NewWriter(pw)
+		_, err = qw.Write(p.content)
+		if err != nil {
+			return nil, err
+		}
+		err = qw.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = mw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	msgID, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	domain := "localhost"
+	if addr, err := mail.ParseAddress(from); err == nil {
+		domain = addr.Address[strings.LastIndex(addr.Address, "@")+1:]
+	}
+
+	var msg bytes.Buffer
+	headers := [][2]string{
+		{"From", from},
+		{"To", to},
+		{"Subject", mime.QEncoding.Encode("utf-8", subject)},
+		{"Date", now.Format(time.RFC1123Z)},
+		{"Message-ID", fmt.Sprintf("<%s@%s>", msgID, domain)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", fmt.Sprintf("multipart/alternative; boundary=%q", mw.Boundary())},
+	}
+	if data.UnsubscribeURL != "" {
+		headers = append(headers,
+			[2]string{"List-Unsubscribe", "<" + data.UnsubscribeURL + ">"},
+			[2]string{"List-Unsubscribe-Post", "List-Unsubscribe=One-Click"},
+		)
+	}
+	for _, h := range headers {
+		fmt.Fprintf(&msg, "%s: %s\r\n", h[0], h[1])
+	}
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+// unsubscribeURL returns the one-click unsubscribe link of the subscriber,
+// or an empty string if UNSUBSCRIBE_URL is not configured
+func unsubscribeURL(sub models.Subscription) string {
+	base := os.Getenv(envUnsubscribeURL)
+	if base == "" || !canUnsubscribe(sub) {
+		return ""
+	}
+
+	q := url.Values{}
+	q.Set("id", sub.ID)
+	q.Set("token", unsubscribeToken(sub))
+	return base + "?" + q.Encode()
+}
+
+// canUnsubscribe returns true for the subscriptions that may be removed through
+// an unsubscribe link, which are only sent to email recipients
+func canUnsubscribe(sub models.Subscription) bool {
+	return subscriptionKind(sub) == models.SubscriptionEmail && sub.Secret != ""
+}
+
+// unsubscribeToken signs the subscription ID with the subscription's secret
+func unsubscribeToken(sub models.Subscription) string {
+	mac := hmac.New(sha256.New, []byte(sub.Secret))
+	mac.Write([]byte(sub.ID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// unsubscribe removes the subscription referenced by a signed unsubscribe link.
+// GET shows a confirmation form so link scanners do not unsubscribe recipients,
+// while mail clients use the one-click POST described in RFC 8058.
+func unsubscribe(w http.ResponseWriter, r *http.Request) {
+	logger, _ := r.Context().Value(sparta.ContextKeyLogger).(*logrus.Logger)
+	lambdaContext, _ := r.Context().Value(sparta.ContextKeyLambdaContext).(*sparta.LambdaContext)
+	logger.WithFields(logrus.Fields{
+		"RequestID": lambdaContext.AWSRequestID,
+	}).Info(requestReceived)
+
+	id := r.URL.Query().Get("id")
+	token := r.URL.Query().Get("token")
+	sub, err := getSubscriptionFromDB(id)
+	if err != nil || !canUnsubscribe(sub) || !hmac.Equal([]byte(token), []byte(unsubscribeToken(sub))) {
+		writeRespHeaderWithMsg(w, http.StatusNotFound, unsubscribeErr)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		writeRespHeaderWithMsg(w, http.StatusOK, fmt.Sprintf(
+			`<form method="post"><p>Stop sending King's Raid articles to %s?</p><button type="submit">Unsubscribe</button></form>`,
+			htmltemplate.HTMLEscapeString(sub.Email)))
+	case http.MethodPost:
+		err = removeSubscriptionFromDB(sub.ID)
+		if err != nil {
+			logger.Error("Unsubscribe Error Remove :", err.Error())
+			writeRespHeaderWithMsg(w, http.StatusInternalServerError, dbWriteErr)
+			return
+		}
+		logger.WithFields(logrus.Fields{
+			"SubscriptionID": sub.ID,
+		}).Info("Unsubscribed")
+		writeRespHeaderWithMsg(w, http.StatusOK, unsubscribeSuccess)
+	default:
+		writeRespHeaderWithMsg(w, http.StatusMethodNotAllowed, methodNotAllowedError)
+	}
+}
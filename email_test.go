@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"github.com/Sirupsen/logrus"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpSink is a local SMTP server keeping the messages it accepts
+type smtpSink struct {
+	ln       net.Listener
+	messages chan smtpMessage
+}
+
+type smtpMessage struct {
+	from, to, data string
+}
+
+// newSMTPSink starts a sink on a loopback port. A stalled sink accepts
+// connections but never greets the client.
+func newSMTPSink(t *testing.T, stalled bool) *smtpSink {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpSink{ln: ln, messages: make(chan smtpMessage, 10)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if stalled {
+				defer conn.Close()
+				continue
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpSink) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	var msg smtpMessage
+	reply("220 localhost ESMTP sink")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250-localhost")
+			reply("250 8BITMIME")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			msg.from = smtpPath(line)
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			msg.to = smtpPath(line)
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			msg.data = data.String()
+			s.messages <- msg
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+// smtpPath returns the address between the angle brackets of a MAIL or RCPT command
+func smtpPath(line string) string {
+	start, end := strings.Index(line, "<"), strings.Index(line, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return line[start+1 : end]
+}
+
+func setSMTPEnv(t *testing.T, addr string) {
+	t.Helper()
+	host, port, _ := net.SplitHostPort(addr)
+	env := map[string]string{
+		envSMTPHost:       host,
+		envSMTPPort:       port,
+		envSMTPFrom:       "King's Raid <crawler@example.com>",
+		envUnsubscribeURL: "https://api.example.com/unsubscribe",
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+	}
+	t.Cleanup(func() {
+		for k := range env {
+			os.Unsetenv(k)
+		}
+	})
+}
+
+func TestNotifyEmail(t *testing.T) {
+	sink := newSMTPSink(t, false)
+	defer sink.ln.Close()
+	setSMTPEnv(t, sink.ln.Addr().String())
+
+	sub := models.Subscription{ID: "mail", Kind: models.SubscriptionEmail, Email: "raider@example.com", Secret: "s3cret"}
+	events := []models.ArticleEvent{
+		{Type: models.ArticleNew, Article: models.Article{ID: 1, Type: models.PATCHNOTES, Title: "Patch 3.0", Desc: "New heroes"}},
+		{Type: models.ArticleRemoved, Article: models.Article{ID: 2, Type: models.NOTICE, Title: "Gone"}},
+	}
+	for i, err := range notifyEmail(sub, events, logrus.New()) {
+		if err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+	}
+
+	select {
+	case msg := <-sink.messages:
+		if msg.from != "crawler@example.com" || msg.to != sub.Email {
+			t.Errorf("envelope = %s -> %s", msg.from, msg.to)
+		}
+		for _, want := range []string{
+			"Subject: " + emailSubject + "Patch 3.0",
+			"List-Unsubscribe: <https://api.example.com/unsubscribe?id=mail&token=" + unsubscribeToken(sub) + ">",
+			"List-Unsubscribe-Post: List-Unsubscribe=One-Click",
+			"Content-Type: text/plain; charset=utf-8",
+			"Content-Type: text/html; charset=utf-8",
+			"New heroes",
+		} {
+			if !strings.Contains(msg.data, want) {
+				t.Errorf("message is missing %q", want)
+			}
+		}
+		if strings.Contains(msg.data, "Gone") {
+			t.Error("removed article was sent")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+}
+
+func TestSendEmailTimeout(t *testing.T) {
+	sink := newSMTPSink(t, true)
+	defer sink.ln.Close()
+	setSMTPEnv(t, sink.ln.Addr().String())
+
+	defer func(d time.Duration) { smtpTimeout = d }(smtpTimeout)
+	smtpTimeout = 200 * time.Millisecond
+
+	start := time.Now()
+	sub := models.Subscription{ID: "mail", Kind: models.SubscriptionEmail, Email: "raider@example.com"}
+	err := sendEmail(sub, "stalled", emailData{})
+	if err == nil {
+		t.Fatal("stalled server did not fail the send")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("send took %v with a %v timeout", d, smtpTimeout)
+	}
+}
+
+func TestCanUnsubscribe(t *testing.T) {
+	tests := []struct {
+		sub  models.Subscription
+		want bool
+	}{
+		{models.Subscription{Kind: models.SubscriptionEmail, Secret: "s"}, true},
+		{models.Subscription{Kind: models.SubscriptionEmail}, false},
+		{models.Subscription{Kind: models.SubscriptionWebhook, Secret: "s"}, false},
+		{models.Subscription{Secret: "s"}, false},
+	}
+	for _, tt := range tests {
+		if got := canUnsubscribe(tt.sub); got != tt.want {
+			t.Errorf("canUnsubscribe(%q) = %v, want %v", tt.sub.Kind, got, tt.want)
+		}
+		if url := unsubscribeURL(tt.sub); tt.want == false && url != "" {
+			t.Errorf("unsubscribeURL(%q) = %q", tt.sub.Kind, url)
+		}
+	}
+}
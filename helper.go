@@ -2,56 +2,28 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/Sirupsen/logrus"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/guregu/dynamo"
-	"github.com/mweagle/Sparta/aws/dynamodb"
 	"github.com/xeia/Kings-Raid-Crawler/crawler"
 	"github.com/xeia/Kings-Raid-Crawler/models"
 	"math/rand"
 	"net/http"
-	"os"
-	"strconv"
 	"strings"
 	"time"
 )
 
-func discordHook(ev dynamodb.Event, logger *logrus.Logger) error {
-	var embeds []models.DiscordEmbed
-	for _, rec := range ev.Records {
-		e, err := parseSingleRecord(rec)
-		if err != nil {
-			logger.Error(err)
-		}
-		embeds = append(embeds, e)
-	}
-
-	msg := models.DiscordHookMessage{
-		Content: generateContentString(),
-		Embeds:  embeds,
-	}
-
-	jsonBytes, err := json.Marshal(msg)
-	if err != nil {
-		return err
-	}
-
-	return sendDiscordHook(jsonBytes)
-}
-
-func sendDiscordHook(b []byte) error {
-	dt := os.Getenv(envDiscordHook)
-	if dt == "" {
-		return errors.New(envDiscordHookErr)
-	}
-	return sendHook(dt, b)
+func sendHook(url string, b []byte) error {
+	return doHookRequest(http.MethodPost, url, b, nil)
 }
 
-func sendHook(url string, b []byte) error {
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(b))
+// doHookRequest sends the JSON payload and decodes the response into out, if given
+func doHookRequest(method string, url string, b []byte, out interface{}) error {
+	req, err := http.NewRequest(method, url, bytes.NewBuffer(b))
 	if err != nil {
 		return err
 	}
@@ -59,36 +31,30 @@ func sendHook(url string, b []byte) error {
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
-	defer resp.Body.Close()
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK || resp.StatusCode != http.StatusNoContent {
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
 		return errors.New(fmt.Sprintf("Response code received: %d", resp.StatusCode))
 	}
-	return nil
-}
 
-func parseSingleRecord(rec dynamodb.EventRecord) (models.DiscordEmbed, error) {
-	var embed models.DiscordEmbed
-	embed.Title = *rec.DynamoDB.NewImage["article-title"].S
-	embed.Description = *rec.DynamoDB.NewImage["article-description"].S
-	embed.Thumbnail = models.DiscordThumbnail{URL: *rec.DynamoDB.NewImage["article-thumb-url"].S}
-
-	articleID, err := strconv.Atoi(*rec.DynamoDB.NewImage["article-id"].N)
-	if err != nil {
-		return embed, err
+	if out != nil && resp.StatusCode == http.StatusOK {
+		return json.NewDecoder(resp.Body).Decode(out)
 	}
+	return nil
+}
 
-	articleType, err := strconv.Atoi(*rec.DynamoDB.NewImage["article-type"].N)
-	if err != nil {
-		return embed, err
+// generateArticleEmbed formats an article as a discord embed
+func generateArticleEmbed(a models.Article) models.DiscordEmbed {
+	return models.DiscordEmbed{
+		Title:       a.Title,
+		Description: truncate(articleDescription(a, crawler.DiscordMarkdown), maxDiscordDescription),
+		URL:         formatArticleURL(a.Type, a.ID),
+		Color:       generateColorCode(a.Type),
+		Thumbnail:   models.DiscordThumbnail{URL: a.ImgURL},
 	}
-
-	embed.URL = formatArticleURL(models.ArticleType(articleType), articleID)
-	embed.Color = generateColorCode(models.ArticleType(articleType))
-	return embed, nil
 }
 
 func formatArticleURL(at models.ArticleType, id int) string {
@@ -125,7 +91,7 @@ func generateContentString() string {
 	return s[rand.Intn(len(s))]
 }
 
-func addArticlesToDB(articles []models.Article) ([]models.Article, error) {
+func addArticlesToDB(ctx context.Context, articles []models.Article) ([]models.Article, error) {
 	sess := session.Must(session.NewSession())
 	db := dynamo.New(sess)
 	table := db.Table(models.ArticleTable)
@@ -135,14 +101,17 @@ func addArticlesToDB(articles []models.Article) ([]models.Article, error) {
 	// just do it sequentially
 	var results []models.Article
 	for _, article := range articles {
-		article.CreatedOn = time.Now()
-		article.ModifiedOn = time.Now()
+		article.CreatedOn = time.Now().UTC()
+		article.ModifiedOn = time.Now().UTC()
 
 		// hackish conditional update to accomodate article revisions
 		var oldArticle models.Article
 		err := table.Get(models.ArticleIDCol, article.ID).One(&oldArticle)
-		if strings.Compare(err.Error(), dynamo.ErrNotFound.Error()) == 0 {
-			err = table.Put(article).If("attribute_not_exists(article-id)").Run()
+		if err == dynamo.ErrNotFound {
+			article.Revision = 1
+			article = mirrorArticleImage(ctx, article, oldArticle)
+			article = fetchArticleBody(ctx, article)
+			err = table.Put(article).If("attribute_not_exists($)", models.ArticleIDCol).Run()
 			if err != nil {
 				success = false
 			} else {
@@ -151,12 +120,24 @@ func addArticlesToDB(articles []models.Article) ([]models.Article, error) {
 			}
 		} else if err == nil && strings.Compare(oldArticle.Title, article.Title) != 0 {
 			article.CreatedOn = oldArticle.CreatedOn
+			article.Revision = oldArticle.Revision + 1
+			article = mirrorArticleImage(ctx, article, oldArticle)
+			article = fetchArticleBody(ctx, article)
 			err = table.Put(article).Run()
 			if err != nil {
 				success = false
 			} else {
 				results = append(results, article)
 			}
+		} else if err == nil && article.Author != "" && article.Author != oldArticle.Author {
+			// keep the revision so the stream does not report an edit
+			err = table.Update(models.ArticleIDCol, article.ID).
+				Range(models.ArticleTypeCol, article.Type).
+				Set(models.ArticleAuthorCol, article.Author).
+				Run()
+			if err != nil {
+				success = false
+			}
 		}
 	}
 
@@ -166,6 +147,53 @@ func addArticlesToDB(articles []models.Article) ([]models.Article, error) {
 	return results, errors.New(dbWriteErr)
 }
 
+// refreshArticleViews stores the view counts of the listed articles. Failing
+// to do so only leaves the previous counts until the next scrape.
+func refreshArticleViews(articles []models.Article, typ models.ArticleType, logger *logrus.Logger) {
+	for _, a := range articles {
+		if a.Views < 1 {
+			continue
+		}
+		if err := updateArticleViewsInDB(a.ID, a.Views); err != nil {
+			logger.WithFields(logrus.Fields{
+				"ArticleType": typ.String(),
+				"ArticleID":   a.ID,
+			}).Warn("Scrape Error Views :", err.Error())
+		}
+	}
+}
+
+// updateArticleViewsInDB refreshes the view count of the article, keeping
+// its other stats
+func updateArticleViewsInDB(id int, views int) error {
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+	table := db.Table(models.ArticleStatsTable)
+	return table.Update(models.ArticleStatsArticleIDCol, id).
+		Set(models.ArticleStatsViewsCol, views).
+		Set(models.ArticleStatsUpdatedOnCol, time.Now()).
+		Run()
+}
+
+// putArticleToDB overwrites the stored article without changing its revision,
+// so the stream does not report it as edited
+func putArticleToDB(a models.Article) error {
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+	table := db.Table(models.ArticleTable)
+	return table.Put(a).Run()
+}
+
+func getArticleFromDB(id int) (models.Article, error) {
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+
+	var a models.Article
+	aTable := db.Table(models.ArticleTable)
+	err := aTable.Get(models.ArticleIDCol, id).One(&a)
+	return a, err
+}
+
 func getArticlesFromDB() ([]models.Article, error) {
 	sess := session.Must(session.NewSession())
 	db := dynamo.New(sess)
@@ -176,6 +204,28 @@ func getArticlesFromDB() ([]models.Article, error) {
 	return res, err
 }
 
+// searchArticlesFromDB returns all articles whose title or description
+// contains the query, ignoring case
+func searchArticlesFromDB(query string) ([]models.Article, error) {
+	var res []models.Article
+	q := strings.ToLower(strings.TrimSpace(query))
+	if q == "" {
+		return res, nil
+	}
+
+	all, err := getArticlesFromDB()
+	if err != nil {
+		return res, err
+	}
+
+	for _, a := range all {
+		if strings.Contains(strings.ToLower(a.Title), q) || strings.Contains(strings.ToLower(a.Desc), q) {
+			res = append(res, a)
+		}
+	}
+	return res, nil
+}
+
 func getLatestArticleByTypeFromDB(at models.ArticleType, limit int64) ([]models.Article, error) {
 	sess := session.Must(session.NewSession())
 	db := dynamo.New(sess)
@@ -254,21 +304,25 @@ func getLargestID(articles []models.Article) int {
 
 func convertURLReqType(a string) (models.ArticleType, error) {
 	switch strings.ToLower(a) {
-	case "event":
-	case "events":
+	case "event", "events":
 		return models.EVENTS, nil
-	case "notice":
-	case "notices":
+	case "notice", "notices":
 		return models.NOTICE, nil
-	case "patch":
-	case "patchnotes":
-	case "patch_notes":
-	case "patch-notes":
+	case "patch", "patchnotes", "patch_notes", "patch-notes":
 		return models.PATCHNOTES, nil
 	}
 	return models.NOTICE, errors.New("No known article-type found")
 }
 
+// splitS3URL splits s3://bucket/key into its bucket and key
+func splitS3URL(u string) (string, string) {
+	path := strings.TrimPrefix(u, "s3://")
+	if i := strings.Index(path, "/"); i >= 0 {
+		return path[:i], path[i+1:]
+	}
+	return path, ""
+}
+
 func writeRespHeaderWithMsg(w http.ResponseWriter, status int, message string) {
 	w.WriteHeader(status)
 	w.Write([]byte(message))
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/Sirupsen/logrus"
+	"github.com/mweagle/Sparta"
+	"io"
+	"net/http"
+	"net/http/httptest"
+)
+
+// newLambdaRequest returns a request carrying the logger and lambda context
+// that Sparta passes to the handlers
+func newLambdaRequest(method string, target string, body io.Reader) *http.Request {
+	r := httptest.NewRequest(method, target, body)
+	ctx := context.WithValue(r.Context(), sparta.ContextKeyLogger, logrus.New())
+	ctx = context.WithValue(ctx, sparta.ContextKeyLambdaContext, &sparta.LambdaContext{AWSRequestID: "test"})
+	return r.WithContext(ctx)
+}
+
+var errTest = errors.New("test failure")
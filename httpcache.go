@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/guregu/dynamo"
+	"github.com/xeia/Kings-Raid-Crawler/crawler"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"time"
+)
+
+// httpCache stores the validators of the scraped pages in dynamo
+type httpCache struct{}
+
+func (httpCache) GetValidators(url string) (crawler.Validators, error) {
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+
+	var c models.HTTPCache
+	table := db.Table(models.HTTPCacheTable)
+	err := table.Get(models.HTTPCacheURLCol, url).One(&c)
+	return crawler.Validators{ETag: c.ETag, LastModified: c.LastModified}, err
+}
+
+func (httpCache) PutValidators(url string, v crawler.Validators) error {
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+	table := db.Table(models.HTTPCacheTable)
+	return table.Put(models.HTTPCache{
+		URL:          url,
+		ETag:         v.ETag,
+		LastModified: v.LastModified,
+		UpdatedOn:    time.Now(),
+	}).Run()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"github.com/Sirupsen/logrus"
+	"github.com/xeia/Kings-Raid-Crawler/mirror"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+)
+
+// imageMirror is nil unless MIRROR_BUCKET is set, or MIRROR_DIR along with
+// the public URL it is served from
+var imageMirror *mirror.Mirror
+
+// mirrorArticleImage replaces the thumbnail of the article with its mirrored
+// copy, reusing the copy of the stored article if the thumbnail did not change.
+// The cafe's URL is kept when mirroring fails, so the article is still stored.
+func mirrorArticleImage(ctx context.Context, article models.Article, old models.Article) models.Article {
+	if imageMirror == nil || article.ImgURL == "" {
+		return article
+	}
+	if old.OriginalImgURL != "" && old.OriginalImgURL == article.ImgURL {
+		article.ImgURL, article.OriginalImgURL = old.ImgURL, old.OriginalImgURL
+		return article
+	}
+
+	u, err := imageMirror.Image(ctx, article.ImgURL)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"ArticleID": article.ID,
+			"ImgURL":    article.ImgURL,
+		}).Warn("Mirror Error :", err.Error())
+		return article
+	}
+	article.ImgURL, article.OriginalImgURL = u, article.ImgURL
+	return article
+}
+
+// mirrorImages returns the function replacing images of the article's body by
+// their mirrored copies. Images appearing several times are mirrored once, and
+// the cafe's URL is kept when mirroring fails.
+func mirrorImages(ctx context.Context, id int) func(src string) string {
+	mirrored := make(map[string]string)
+	return func(src string) string {
+		if imageMirror == nil {
+			return src
+		}
+		if u, ok := mirrored[src]; ok {
+			return u
+		}
+
+		u, err := imageMirror.Image(ctx, src)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"ArticleID": id,
+				"ImgURL":    src,
+			}).Warn("Mirror Error :", err.Error())
+			u = src
+		}
+		mirrored[src] = u
+		return u
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/mweagle/Sparta"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+)
+
+const (
+	headerSignature          = "X-Signature-Ed25519"
+	headerSignatureTimestamp = "X-Signature-Timestamp"
+
+	interactionSignatureErr = "invalid request signature"
+	interactionUnknownErr   = "unknown interaction type"
+
+	// maximum number of embeds Discord accepts in a single message
+	maxDiscordEmbeds   = 10
+	searchDefaultCount = 5
+
+	krCommand        = "kr"
+	krLatest         = "latest"
+	krPatch          = "patch"
+	krNotice         = "notice"
+	krEvent          = "event"
+	krSearch         = "search"
+	krCoupons        = "coupons"
+	krOptionCount    = "count"
+	krOptionQuery    = "query"
+	couponSearchTerm = "coupon"
+
+	commandNoResults = "I couldn't find any articles for that, sorry!"
+	commandUnknown   = "I don't know that command yet!"
+)
+
+var commandArticleTypes = map[string]models.ArticleType{
+	krPatch:  models.PATCHNOTES,
+	krNotice: models.NOTICE,
+	krEvent:  models.EVENTS,
+}
+
+func handleInteraction(w http.ResponseWriter, r *http.Request) {
+	logger, _ := r.Context().Value(sparta.ContextKeyLogger).(*logrus.Logger)
+	lambdaContext, _ := r.Context().Value(sparta.ContextKeyLambdaContext).(*sparta.LambdaContext)
+	logger.WithFields(logrus.Fields{
+		"RequestID": lambdaContext.AWSRequestID,
+	}).Info(requestReceived)
+
+	body, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		writeRespHeaderWithMsg(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	pubKey, err := discordPublicKey()
+	if err != nil {
+		logger.Error("Interaction Error :", err.Error())
+		writeRespHeaderWithMsg(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	sig := r.Header.Get(headerSignature)
+	ts := r.Header.Get(headerSignatureTimestamp)
+	if !verifyInteractionSignature(pubKey, sig, ts, body) {
+		logger.Warn("Interaction rejected: ", interactionSignatureErr)
+		writeRespHeaderWithMsg(w, http.StatusUnauthorized, interactionSignatureErr)
+		return
+	}
+
+	var interaction models.DiscordInteraction
+	err = json.Unmarshal(body, &interaction)
+	if err != nil {
+		writeRespHeaderWithMsg(w, http.StatusBadRequest, eventReadErr+err.Error())
+		return
+	}
+
+	resp, err := respondToInteraction(interaction)
+	if err != nil {
+		logger.Error("Interaction Error :", err.Error())
+		writeRespHeaderWithMsg(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeRespJSON(w, resp)
+}
+
+// discordPublicKey reads the application's public key from the environment
+func discordPublicKey() (ed25519.PublicKey, error) {
+	k := os.Getenv(envDiscordPublicKey)
+	if k == "" {
+		return nil, errors.New(envDiscordPublicKeyErr)
+	}
+
+	b, err := hex.DecodeString(k)
+	if err != nil || len(b) != ed25519.PublicKeySize {
+		return nil, errors.New(envDiscordPublicKeyErr)
+	}
+	return ed25519.PublicKey(b), nil
+}
+
+// verifyInteractionSignature returns true if sig is a valid signature of
+// timestamp+body for the given public key
+func verifyInteractionSignature(pubKey ed25519.PublicKey, sig string, timestamp string, body []byte) bool {
+	if sig == "" || timestamp == "" {
+		return false
+	}
+
+	s, err := hex.DecodeString(sig)
+	if err != nil || len(s) != ed25519.SignatureSize {
+		return false
+	}
+
+	msg := append([]byte(timestamp), body...)
+	return ed25519.Verify(pubKey, msg, s)
+}
+
+func respondToInteraction(interaction models.DiscordInteraction) (models.DiscordInteractionResponse, error) {
+	switch interaction.Type {
+	case models.InteractionPing:
+		return models.DiscordInteractionResponse{Type: models.CallbackPong}, nil
+	case models.InteractionApplicationCommand:
+		data := handleCommand(interaction.Data)
+		return models.DiscordInteractionResponse{
+			Type: models.CallbackChannelMessageWithSource,
+			Data: &data,
+		}, nil
+	}
+	return models.DiscordInteractionResponse{}, errors.New(interactionUnknownErr)
+}
+
+// handleCommand dispatches a /kr sub command to the matching query
+func handleCommand(data models.DiscordInteractionData) models.DiscordInteractionCallbackData {
+	if data.Name != krCommand || len(data.Options) == 0 {
+		return ephemeralMessage(commandUnknown)
+	}
+
+	sub := data.Options[0]
+	var articles []models.Article
+	var err error
+
+	switch sub.Name {
+	case krLatest:
+		var a models.Article
+		a, err = getLatestArticleFromDB()
+		articles = append(articles, a)
+	case krPatch, krNotice, krEvent:
+		articles, err = getLatestArticleByTypeFromDB(commandArticleTypes[sub.Name], commandCount(sub, 1))
+	case krSearch:
+		articles, err = searchArticlesFromDB(commandString(sub, krOptionQuery))
+		articles = limitArticles(articles, commandCount(sub, searchDefaultCount))
+	case krCoupons:
+		articles, err = searchArticlesFromDB(couponSearchTerm)
+		articles = limitArticles(articles, commandCount(sub, searchDefaultCount))
+	default:
+		return ephemeralMessage(commandUnknown)
+	}
+
+	if err != nil {
+		return ephemeralMessage(fmt.Sprintf("Something went wrong: %s", err.Error()))
+	}
+
+	var embeds []models.DiscordEmbed
+	for _, a := range articles {
+		if a.ID > 0 {
+			embeds = append(embeds, generateArticleEmbed(a))
+		}
+	}
+	if len(embeds) == 0 {
+		return ephemeralMessage(commandNoResults)
+	}
+	return models.DiscordInteractionCallbackData{Embeds: embeds}
+}
+
+// commandCount returns the count option of a sub command bounded to the embed limit
+func commandCount(opt models.DiscordInteractionOption, def int64) int64 {
+	for _, o := range opt.Options {
+		if o.Name != krOptionCount {
+			continue
+		}
+
+		// json numbers are decoded as float64
+		if v, ok := o.Value.(float64); ok && v >= 1 {
+			if v > maxDiscordEmbeds {
+				return maxDiscordEmbeds
+			}
+			return int64(v)
+		}
+	}
+	return def
+}
+
+func commandString(opt models.DiscordInteractionOption, name string) string {
+	for _, o := range opt.Options {
+		if o.Name == name {
+			if v, ok := o.Value.(string); ok {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// limitArticles returns at most limit articles, newest first
+func limitArticles(articles []models.Article, limit int64) []models.Article {
+	sort.Slice(articles, func(i, j int) bool {
+		return articles[i].ID > articles[j].ID
+	})
+	if int64(len(articles)) > limit {
+		return articles[:limit]
+	}
+	return articles
+}
+
+func ephemeralMessage(msg string) models.DiscordInteractionCallbackData {
+	return models.DiscordInteractionCallbackData{
+		Content: msg,
+		Flags:   models.DiscordMessageFlagEphemeral,
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func signedInteraction(t *testing.T, priv ed25519.PrivateKey, body string, tamper bool) *http.Request {
+	t.Helper()
+	ts := "1700000000"
+	sig := ed25519.Sign(priv, []byte(ts+body))
+	if tamper {
+		sig[0] ^= 0xff
+	}
+
+	r := newLambdaRequest(http.MethodPost, "/interactions", strings.NewReader(body))
+	r.Header.Set(headerSignature, hex.EncodeToString(sig))
+	r.Header.Set(headerSignatureTimestamp, ts)
+	return r
+}
+
+func TestHandleInteraction(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(envDiscordPublicKey, hex.EncodeToString(pub))
+	defer os.Unsetenv(envDiscordPublicKey)
+
+	tests := []struct {
+		name     string
+		body     string
+		tamper   bool
+		status   int
+		callback models.DiscordCallbackType
+		content  string
+	}{
+		{"ping", `{"type":1}`, false, http.StatusOK, models.CallbackPong, ""},
+		{"unknown command", `{"type":2,"data":{"name":"other"}}`, false, http.StatusOK, models.CallbackChannelMessageWithSource, commandUnknown},
+		{"unknown sub command", `{"type":2,"data":{"name":"kr","options":[{"name":"dance","type":1}]}}`, false, http.StatusOK, models.CallbackChannelMessageWithSource, commandUnknown},
+		{"bad signature", `{"type":1}`, true, http.StatusUnauthorized, 0, ""},
+		{"unknown type", `{"type":9}`, false, http.StatusBadRequest, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handleInteraction(w, signedInteraction(t, priv, tt.body, tt.tamper))
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var resp models.DiscordInteractionResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Type != tt.callback {
+				t.Errorf("type = %d, want %d", resp.Type, tt.callback)
+			}
+			if tt.content != "" && (resp.Data == nil || resp.Data.Content != tt.content || resp.Data.Flags != models.DiscordMessageFlagEphemeral) {
+				t.Errorf("data = %+v, want ephemeral %q", resp.Data, tt.content)
+			}
+		})
+	}
+}
+
+func TestDiscordPublicKey(t *testing.T) {
+	defer os.Unsetenv(envDiscordPublicKey)
+	for _, k := range []string{"", "zz", hex.EncodeToString(make([]byte, ed25519.PublicKeySize-1))} {
+		os.Setenv(envDiscordPublicKey, k)
+		if _, err := discordPublicKey(); err == nil {
+			t.Errorf("discordPublicKey(%q) accepted an invalid key", k)
+		}
+	}
+}
+
+func TestCommandCount(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  int64
+	}{
+		{nil, 3},
+		{float64(2), 2},
+		{float64(0), 3},
+		{float64(50), maxDiscordEmbeds},
+		{"4", 3},
+	}
+	for _, tt := range tests {
+		opt := models.DiscordInteractionOption{Options: []models.DiscordInteractionOption{{Name: krOptionCount, Value: tt.value}}}
+		if got := commandCount(opt, 3); got != tt.want {
+			t.Errorf("commandCount(%v) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
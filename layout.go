@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/guregu/dynamo"
+	"github.com/xeia/Kings-Raid-Crawler/crawler"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// identical layout problems are reported again after this interval
+	layoutAlertInterval = 6 * time.Hour
+
+	layoutAlertColor = 0xe74c3c
+)
+
+// scrapeType scrapes the menu of the given type, alerting the operators
+// when the page no longer matches the layout expected by the crawler, and
+// refreshes the views of the listed articles
+func scrapeType(ctx context.Context, typ models.ArticleType, logger *logrus.Logger) ([]models.Article, string, crawler.SaveFunc, error) {
+	articles, cHash, save, err := crawler.DefaultSource.Scrape(ctx, typ)
+	if lerr, ok := err.(*crawler.LayoutError); ok {
+		logger.WithFields(logrus.Fields{
+			"ArticleType": typ.String(),
+			"Problems":    lerr.Problems,
+		}).Error("Scrape Layout Changed")
+
+		alertErr := raiseLayoutAlert(typ, lerr, time.Now())
+		if alertErr != nil {
+			logger.Error("Layout Alert Error :", alertErr.Error())
+		}
+	} else if err == nil {
+		// views are left out of the listing hash, so they are refreshed here
+		// rather than with the articles, which unchanged listings skip
+		refreshArticleViews(articles, typ, logger)
+	}
+	return articles, cHash, save, err
+}
+
+// saveValidators caches the validators of a scraped page once its articles
+// are stored. Failing to do so only costs a full fetch on the next scrape.
+func saveValidators(save crawler.SaveFunc, typ models.ArticleType, logger *logrus.Logger) {
+	if err := save(); err != nil {
+		logger.WithFields(logrus.Fields{
+			"ArticleType": typ.String(),
+		}).Warn("Scrape Error Save Validators :", err.Error())
+	}
+}
+
+// raiseLayoutAlert posts the layout problems to ADMIN_WEBHOOK with the
+// offending page attached, unless they were already reported recently
+func raiseLayoutAlert(typ models.ArticleType, lerr *crawler.LayoutError, now time.Time) error {
+	hook := os.Getenv(envAdminWebhook)
+	if hook == "" {
+		return errors.New(envAdminWebhookErr)
+	}
+
+	problemsHash := fmt.Sprintf("%x", sha1.Sum([]byte(strings.Join(lerr.Problems, "\n"))))
+	last, err := getLayoutAlertFromDB(typ)
+	if err == nil && last.ProblemsHash == problemsHash && now.Sub(last.AlertedOn) < layoutAlertInterval {
+		return nil
+	} else if err != nil && err != dynamo.ErrNotFound {
+		return err
+	}
+
+	msg := models.DiscordHookMessage{
+		Content: fmt.Sprintf("Layout change detected while scraping %s, its articles were not stored.", typ.String()),
+		Embeds: []models.DiscordEmbed{{
+			Title:       "Unexpected layout",
+			URL:         lerr.URL,
+			Description: truncate("• "+strings.Join(lerr.Problems, "\n• "), maxDiscordDescription),
+			Color:       layoutAlertColor,
+			Timestamp:   now.UTC().Format(time.RFC3339),
+		}},
+	}
+	filename := fmt.Sprintf("%s-%s.html", strings.ToLower(strings.Replace(typ.String(), " ", "-", -1)), now.UTC().Format("20060102T150405Z"))
+	err = sendHookWithFile(hook, msg, filename, lerr.HTML)
+	if err != nil {
+		return err
+	}
+
+	return putLayoutAlertToDB(models.LayoutAlert{Type: typ, ProblemsHash: problemsHash, AlertedOn: now})
+}
+
+// sendHookWithFile posts the message to a discord webhook with the file attached
+func sendHookWithFile(url string, msg models.DiscordHookMessage, filename string, file []byte) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	err = mw.WriteField("payload_json", string(payload))
+	if err != nil {
+		return err
+	}
+	fw, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write(file)
+	if err != nil {
+		return err
+	}
+	err = mw.Close()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("Response code received: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func getLayoutAlertFromDB(typ models.ArticleType) (models.LayoutAlert, error) {
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+
+	var la models.LayoutAlert
+	table := db.Table(models.LayoutAlertTable)
+	err := table.Get(models.LayoutAlertTypeCol, typ).One(&la)
+	return la, err
+}
+
+func putLayoutAlertToDB(la models.LayoutAlert) error {
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+	table := db.Table(models.LayoutAlertTable)
+	return table.Put(la).Run()
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/Sirupsen/logrus"
 	_ "github.com/joho/godotenv/autoload"
@@ -8,10 +9,15 @@ import (
 	"github.com/mweagle/Sparta/aws/dynamodb"
 	gocf "github.com/mweagle/go-cloudformation"
 	"github.com/xeia/Kings-Raid-Crawler/crawler"
+	"github.com/xeia/Kings-Raid-Crawler/mirror"
 	"github.com/xeia/Kings-Raid-Crawler/models"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"time"
+	// the Lambda runtime has no zoneinfo database for the subscribers' timezones
+	_ "time/tzdata"
 )
 
 const (
@@ -19,9 +25,45 @@ const (
 	envDynamoDBStreamErr = "env DYNAMO_DBSTREAM does not exist"
 
 	envDiscordHook    = "DISCORD_WEBHOOK"
-	envDiscordHookErr = "env DISCORD_WEBHOOK does not exist"
 	enableDiscordHook = true
 
+	envDiscordPublicKey    = "DISCORD_PUBLIC_KEY"
+	envDiscordPublicKeyErr = "env DISCORD_PUBLIC_KEY does not exist or is not a valid key"
+
+	envAdminToken = "ADMIN_TOKEN"
+
+	envCrawlerRules     = "CRAWLER_RULES"
+	envCafeAPIURL       = "CAFE_API_URL"
+	envCrawlerUserAgent = "CRAWLER_USER_AGENT"
+	envCrawlerInterval  = "CRAWLER_INTERVAL"
+
+	envCafeCommentsURL       = "CAFE_COMMENTS_URL"
+	envStaffAccounts         = "CAFE_STAFF_ACCOUNTS"
+	envCommentTrackingPeriod = "COMMENT_TRACKING_PERIOD"
+
+	envMirrorBucket  = "MIRROR_BUCKET"
+	envMirrorDir     = "MIRROR_DIR"
+	envMirrorBaseURL = "MIRROR_BASE_URL"
+
+	envSnapshotBucket = "SNAPSHOT_BUCKET"
+	envSnapshotDir    = "SNAPSHOT_DIR"
+
+	envAdminWebhook    = "ADMIN_WEBHOOK"
+	envAdminWebhookErr = "env ADMIN_WEBHOOK does not exist"
+
+	envSMTPHost       = "SMTP_HOST"
+	envSMTPPort       = "SMTP_PORT"
+	envSMTPUsername   = "SMTP_USERNAME"
+	envSMTPPassword   = "SMTP_PASSWORD"
+	envSMTPFrom       = "SMTP_FROM"
+	envSMTPErr        = "env SMTP_HOST or SMTP_FROM does not exist"
+	envUnsubscribeURL = "UNSUBSCRIBE_URL"
+
+	envVAPIDPrivateKey = "VAPID_PRIVATE_KEY"
+	envVAPIDSubject    = "VAPID_SUBJECT"
+	envVAPIDErr        = "env VAPID_PRIVATE_KEY does not exist or is not a valid key"
+	envVAPIDSubjectErr = "env VAPID_SUBJECT must be a mailto: or https: URI"
+
 	envTelegram    = "TELEGRAM_TOKEN"
 	envTelegramErr = "env TELEGRAM_TOKEN does not exist"
 	enableTelegram = false
@@ -38,6 +80,13 @@ const (
 	stateUnchanged = "No new articles have been published at this time. Please check back again later."
 )
 
+const (
+	// Lambda timeouts of the scrapers in seconds. The handlers apply them to
+	// their request context, which carries no deadline of its own.
+	scrapeAllTimeout      = 270
+	scrapeCategoryTimeout = 150
+)
+
 func handleNewArticles(w http.ResponseWriter, r *http.Request) {
 	logger, _ := r.Context().Value(sparta.ContextKeyLogger).(*logrus.Logger)
 	lambdaContext, _ := r.Context().Value(sparta.ContextKeyLambdaContext).(*sparta.LambdaContext)
@@ -53,6 +102,7 @@ func handleNewArticles(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		logger.Error(eventReadErr, err.Error())
 		writeRespHeaderWithMsg(w, http.StatusInternalServerError, eventReadErr+err.Error())
+		return
 	}
 
 	for _, rec := range lambdaEvent.Records {
@@ -61,14 +111,16 @@ func handleNewArticles(w http.ResponseWriter, r *http.Request) {
 		}).Info("DynamoDB event")
 	}
 
-	if enableDiscordHook {
-		err = discordHook(lambdaEvent, logger)
-		if err != nil {
-			logger.Error("DiscordHook Error :", err.Error())
-		} else {
-			logger.Info("DiscordHook successfully sent!")
-		}
+	err = notifySubscribers(lambdaEvent, logger)
+	if err != nil {
+		// fail the invocation so the stream batch is retried
+		logger.Error("Notify Error :", err.Error())
+		writeRespHeaderWithMsg(w, http.StatusInternalServerError, err.Error())
+		return
 	}
+	logger.Info("Notifications processed!")
+
+	captureSnapshots(r.Context(), parseStreamEvents(lambdaEvent, logger), logger)
 
 	if enableTelegram {
 		// todo
@@ -156,6 +208,8 @@ func queryLatest(w http.ResponseWriter, r *http.Request) {
 	writeRespJSON(w, b)
 }
 
+// scrapeAll scrapes every category concurrently and responds with a report
+// per category, failing if any of them could not be scraped or stored
 func scrapeAll(w http.ResponseWriter, r *http.Request) {
 	logger, _ := r.Context().Value(sparta.ContextKeyLogger).(*logrus.Logger)
 	lambdaContext, _ := r.Context().Value(sparta.ContextKeyLambdaContext).(*sparta.LambdaContext)
@@ -163,54 +217,19 @@ func scrapeAll(w http.ResponseWriter, r *http.Request) {
 		"RequestID": lambdaContext.AWSRequestID,
 	}).Info(requestReceived)
 
-	var result []models.Article
-
-	eventsArticles, eventsHash := crawler.ScrapeEvents()
-	if len(eventsArticles) > 0 && !isArticleStateUnchanged(eventsHash, models.EVENTS, eventsArticles[0].ID) {
-		result = append(result, eventsArticles...)
-		for _, article := range eventsArticles {
-			logger.WithFields(logrus.Fields{
-				"ArticleID":    article.ID,
-				"ArticleTitle": article.Title,
-			}).Info("ScrapeAll Events")
-		}
-	}
+	ctx, cancel := withLambdaTimeout(r, scrapeAllTimeout)
+	defer cancel()
 
-	noticeArticles, noticeHash := crawler.ScrapeNotices()
-	if len(noticeArticles) > 0 && !isArticleStateUnchanged(noticeHash, models.NOTICE, noticeArticles[0].ID) {
-		result = append(result, noticeArticles...)
-		for _, article := range noticeArticles {
-			logger.WithFields(logrus.Fields{
-				"ArticleID":    article.ID,
-				"ArticleTitle": article.Title,
-			}).Info("ScrapeAll Notices")
-		}
-	}
-
-	patchNotesArticles, patchNotesHash := crawler.ScrapePatchNotes()
-	if len(patchNotesArticles) > 0 && !isArticleStateUnchanged(patchNotesHash, models.PATCHNOTES, patchNotesArticles[0].ID) {
-		result = append(result, patchNotesArticles...)
-		for _, article := range patchNotesArticles {
-			logger.WithFields(logrus.Fields{
-				"ArticleID":    article.ID,
-				"ArticleTitle": article.Title,
-			}).Info("ScrapeAll Patch Notes")
-		}
-	}
+	report := scrapeCategories(ctx, []models.ArticleType{models.EVENTS, models.NOTICE, models.PATCHNOTES}, logger)
+	logger.WithFields(logrus.Fields{
+		"Report": report.Categories,
+	}).Info("ScrapeAll Complete")
 
-	if len(result) < 1 {
-		logger.Info("ScrapeAll Unchanged")
-		writeRespHeaderWithMsg(w, http.StatusNotModified, stateUnchanged)
-		return
-	}
-	_, err := addArticlesToDB(result)
-	if err != nil {
-		logger.Error("ScrapeAll Error Add", err.Error())
-		writeRespHeaderWithMsg(w, http.StatusInternalServerError, err.Error())
-	} else {
-		logger.Info("ScrapeAll Complete")
-		writeRespHeaderWithMsg(w, http.StatusOK, scrapeComplete)
+	w.Header().Set("Content-Type", "application/json")
+	if report.Failed() {
+		w.WriteHeader(http.StatusInternalServerError)
 	}
+	json.NewEncoder(w).Encode(report)
 }
 
 func scrapeEvents(w http.ResponseWriter, r *http.Request) {
@@ -220,18 +239,32 @@ func scrapeEvents(w http.ResponseWriter, r *http.Request) {
 		"RequestID": lambdaContext.AWSRequestID,
 	}).Info(requestReceived)
 
-	articles, articleHash := crawler.ScrapeEvents()
+	ctx, cancel := withLambdaTimeout(r, scrapeCategoryTimeout)
+	defer cancel()
+
+	articles, articleHash, save, err := scrapeType(ctx, models.EVENTS, logger)
+	if err == crawler.ErrNotModified {
+		logger.Info("ScrapeEvents Not Modified")
+		writeRespHeaderWithMsg(w, http.StatusOK, stateUnchanged)
+		return
+	} else if err != nil {
+		logger.Error("ScrapeEvents Error Scrape", err.Error())
+		writeRespHeaderWithMsg(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 	if len(articles) < 1 || (len(articles) > 0 && isArticleStateUnchanged(articleHash, models.EVENTS, articles[0].ID)) {
+		saveValidators(save, models.EVENTS, logger)
 		logger.Info("ScrapeEvents Unchanged")
-		writeRespHeaderWithMsg(w, http.StatusNotModified, stateUnchanged)
+		writeRespHeaderWithMsg(w, http.StatusOK, stateUnchanged)
 		return
 	}
 
-	_, err := addArticlesToDB(articles)
+	_, err = addArticlesToDB(ctx, articles)
 	if err != nil {
 		logger.Error("ScrapeEvents Error Add", err.Error())
 		writeRespHeaderWithMsg(w, http.StatusInternalServerError, err.Error())
 	} else {
+		saveValidators(save, models.EVENTS, logger)
 		logger.Info("ScrapeEvents Complete")
 		writeRespHeaderWithMsg(w, http.StatusOK, scrapeComplete)
 	}
@@ -244,18 +277,32 @@ func scrapeNotices(w http.ResponseWriter, r *http.Request) {
 		"RequestID": lambdaContext.AWSRequestID,
 	}).Info(requestReceived)
 
-	articles, articleHash := crawler.ScrapeNotices()
+	ctx, cancel := withLambdaTimeout(r, scrapeCategoryTimeout)
+	defer cancel()
+
+	articles, articleHash, save, err := scrapeType(ctx, models.NOTICE, logger)
+	if err == crawler.ErrNotModified {
+		logger.Info("ScrapeNotices Not Modified")
+		writeRespHeaderWithMsg(w, http.StatusOK, stateUnchanged)
+		return
+	} else if err != nil {
+		logger.Error("ScrapeNotices Error Scrape", err.Error())
+		writeRespHeaderWithMsg(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 	if len(articles) < 1 || (len(articles) > 0 && isArticleStateUnchanged(articleHash, models.NOTICE, articles[0].ID)) {
+		saveValidators(save, models.NOTICE, logger)
 		logger.Info("ScrapeNotices Unchanged")
-		writeRespHeaderWithMsg(w, http.StatusNotModified, stateUnchanged)
+		writeRespHeaderWithMsg(w, http.StatusOK, stateUnchanged)
 		return
 	}
 
-	_, err := addArticlesToDB(articles)
+	_, err = addArticlesToDB(ctx, articles)
 	if err != nil {
 		logger.Error("ScrapeNotices Error Add", err.Error())
 		writeRespHeaderWithMsg(w, http.StatusInternalServerError, err.Error())
 	} else {
+		saveValidators(save, models.NOTICE, logger)
 		logger.Info("ScrapeNotices Complete")
 		writeRespHeaderWithMsg(w, http.StatusOK, scrapeComplete)
 	}
@@ -268,23 +315,43 @@ func scrapePatchNotes(w http.ResponseWriter, r *http.Request) {
 		"RequestID": lambdaContext.AWSRequestID,
 	}).Info(requestReceived)
 
-	articles, articleHash := crawler.ScrapePatchNotes()
+	ctx, cancel := withLambdaTimeout(r, scrapeCategoryTimeout)
+	defer cancel()
+
+	articles, articleHash, save, err := scrapeType(ctx, models.PATCHNOTES, logger)
+	if err == crawler.ErrNotModified {
+		logger.Info("ScrapePatchNotes Not Modified")
+		writeRespHeaderWithMsg(w, http.StatusOK, stateUnchanged)
+		return
+	} else if err != nil {
+		logger.Error("ScrapePatchNotes Error Scrape", err.Error())
+		writeRespHeaderWithMsg(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 	if len(articles) < 1 || (len(articles) > 0 && isArticleStateUnchanged(articleHash, models.PATCHNOTES, articles[0].ID)) {
+		saveValidators(save, models.PATCHNOTES, logger)
 		logger.Info("ScrapePatchNotes Unchanged")
-		writeRespHeaderWithMsg(w, http.StatusNotModified, stateUnchanged)
+		writeRespHeaderWithMsg(w, http.StatusOK, stateUnchanged)
 		return
 	}
 
-	_, err := addArticlesToDB(articles)
+	_, err = addArticlesToDB(ctx, articles)
 	if err != nil {
 		logger.Error("ScrapePatchNotes Error Add", err.Error())
 		writeRespHeaderWithMsg(w, http.StatusInternalServerError, err.Error())
 	} else {
+		saveValidators(save, models.PATCHNOTES, logger)
 		logger.Info("ScrapePatchNotes Complete")
 		writeRespHeaderWithMsg(w, http.StatusOK, scrapeComplete)
 	}
 }
 
+// withLambdaTimeout bounds the request context by the timeout, in seconds,
+// its Lambda function is deployed with
+func withLambdaTimeout(r *http.Request, timeout int64) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), time.Duration(timeout)*time.Second)
+}
+
 func createLambdaOptions(desc string, timeout int64, env map[string]*gocf.StringExpr) *sparta.LambdaFunctionOptions {
 	return &sparta.LambdaFunctionOptions{
 		Description: desc,
@@ -300,21 +367,39 @@ func spartaLambdaFunctions(api *sparta.API) []*sparta.LambdaAWSInfo {
 	envMap[envDynamoDBStream] = gocf.String(os.Getenv(envDynamoDBStream))
 	envMap[envDiscordHook] = gocf.String(os.Getenv(envDiscordHook))
 	envMap[envTelegram] = gocf.String(os.Getenv(envTelegram))
+	envMap[envDiscordPublicKey] = gocf.String(os.Getenv(envDiscordPublicKey))
+	envMap[envAdminToken] = gocf.String(os.Getenv(envAdminToken))
+	envMap[envAdminWebhook] = gocf.String(os.Getenv(envAdminWebhook))
+	envMap[envCrawlerRules] = gocf.String(os.Getenv(envCrawlerRules))
+	envMap[envCafeAPIURL] = gocf.String(os.Getenv(envCafeAPIURL))
+	envMap[envCrawlerUserAgent] = gocf.String(os.Getenv(envCrawlerUserAgent))
+	envMap[envCrawlerInterval] = gocf.String(os.Getenv(envCrawlerInterval))
+	envMap[envCafeCommentsURL] = gocf.String(os.Getenv(envCafeCommentsURL))
+	envMap[envStaffAccounts] = gocf.String(os.Getenv(envStaffAccounts))
+	envMap[envCommentTrackingPeriod] = gocf.String(os.Getenv(envCommentTrackingPeriod))
+	envMap[envMirrorBucket] = gocf.String(os.Getenv(envMirrorBucket))
+	envMap[envMirrorDir] = gocf.String(os.Getenv(envMirrorDir))
+	envMap[envMirrorBaseURL] = gocf.String(os.Getenv(envMirrorBaseURL))
+	envMap[envSnapshotBucket] = gocf.String(os.Getenv(envSnapshotBucket))
+	envMap[envSnapshotDir] = gocf.String(os.Getenv(envSnapshotDir))
+	for _, env := range []string{envSMTPHost, envSMTPPort, envSMTPUsername, envSMTPPassword, envSMTPFrom, envUnsubscribeURL, envVAPIDPrivateKey, envVAPIDSubject} {
+		envMap[env] = gocf.String(os.Getenv(env))
+	}
 
 	scrapeAllFn := sparta.HandleAWSLambda("Scrape All", http.HandlerFunc(scrapeAll), sparta.IAMRoleDefinition{})
-	scrapeAllFn.Options = createLambdaOptions("Scrapes PLUG cafe for notices/events/patch notes", 270, envMap)
+	scrapeAllFn.Options = createLambdaOptions("Scrapes PLUG cafe for notices/events/patch notes", scrapeAllTimeout, envMap)
 	lambdaFunctions = append(lambdaFunctions, scrapeAllFn)
 
 	scrapeEventsFn := sparta.HandleAWSLambda("Scrape Events", http.HandlerFunc(scrapeEvents), sparta.IAMRoleDefinition{})
-	scrapeEventsFn.Options = createLambdaOptions("Scrapes PLUG cafe for events", 150, envMap)
+	scrapeEventsFn.Options = createLambdaOptions("Scrapes PLUG cafe for events", scrapeCategoryTimeout, envMap)
 	lambdaFunctions = append(lambdaFunctions, scrapeEventsFn)
 
 	scrapeNoticesFn := sparta.HandleAWSLambda("Scrape Notices", http.HandlerFunc(scrapeNotices), sparta.IAMRoleDefinition{})
-	scrapeNoticesFn.Options = createLambdaOptions("Scrapes PLUG cafe for notices", 150, envMap)
+	scrapeNoticesFn.Options = createLambdaOptions("Scrapes PLUG cafe for notices", scrapeCategoryTimeout, envMap)
 	lambdaFunctions = append(lambdaFunctions, scrapeNoticesFn)
 
 	scrapePatchNotesFn := sparta.HandleAWSLambda("Scrape Patch Notes", http.HandlerFunc(scrapePatchNotes), sparta.IAMRoleDefinition{})
-	scrapePatchNotesFn.Options = createLambdaOptions("Scrapes PLUG cafe for patch notes", 150, envMap)
+	scrapePatchNotesFn.Options = createLambdaOptions("Scrapes PLUG cafe for patch notes", scrapeCategoryTimeout, envMap)
 	lambdaFunctions = append(lambdaFunctions, scrapePatchNotesFn)
 
 	handleArticleFn := sparta.HandleAWSLambda("Handle New Articles", http.HandlerFunc(handleNewArticles), sparta.IAMRoleDefinition{})
@@ -343,9 +428,70 @@ func spartaLambdaFunctions(api *sparta.API) []*sparta.LambdaAWSInfo {
 	queryLatestFn.Options = createLambdaOptions("Queries the database to retrieve the latest article", 10, envMap)
 	lambdaFunctions = append(lambdaFunctions, queryLatestFn)
 
+	subscriptionsFn := sparta.HandleAWSLambda("Manage Subscriptions", http.HandlerFunc(manageSubscriptions), sparta.IAMRoleDefinition{})
+	subscriptionsFn.Options = createLambdaOptions("Adds, lists and removes webhook subscriptions", 30, envMap)
+	lambdaFunctions = append(lambdaFunctions, subscriptionsFn)
+
+	retryFn := sparta.HandleAWSLambda("Retry Deliveries", http.HandlerFunc(retryDeliveries), sparta.IAMRoleDefinition{})
+	retryFn.Options = createLambdaOptions("Re-sends failed deliveries from the outbox", 150, envMap)
+	retryPermission := sparta.CloudWatchEventsPermission{}
+	retryPermission.Rules = make(map[string]sparta.CloudWatchEventsRule)
+	retryPermission.Rules["RetryDeliveries"] = sparta.CloudWatchEventsRule{
+		Description:        "Retries failed deliveries every 5 minutes",
+		ScheduleExpression: "rate(5 minutes)",
+	}
+	retryFn.Permissions = append(retryFn.Permissions, retryPermission)
+	lambdaFunctions = append(lambdaFunctions, retryFn)
+
+	digestFn := sparta.HandleAWSLambda("Send Digests", http.HandlerFunc(sendDigests), sparta.IAMRoleDefinition{})
+	digestFn.Options = createLambdaOptions("Sends daily and weekly digests to subscribers", 150, envMap)
+	digestPermission := sparta.CloudWatchEventsPermission{}
+	digestPermission.Rules = make(map[string]sparta.CloudWatchEventsRule)
+	digestPermission.Rules["SendDigests"] = sparta.CloudWatchEventsRule{
+		Description:        "Sends digests every day at 09:00 UTC",
+		ScheduleExpression: "cron(0 9 * * ? *)",
+	}
+	digestFn.Permissions = append(digestFn.Permissions, digestPermission)
+	lambdaFunctions = append(lambdaFunctions, digestFn)
+
+	commentsFn := sparta.HandleAWSLambda("Track Comments", http.HandlerFunc(trackComments), sparta.IAMRoleDefinition{})
+	commentsFn.Options = createLambdaOptions("Tracks comments and reactions on recent articles", 270, envMap)
+	commentsPermission := sparta.CloudWatchEventsPermission{}
+	commentsPermission.Rules = make(map[string]sparta.CloudWatchEventsRule)
+	commentsPermission.Rules["TrackComments"] = sparta.CloudWatchEventsRule{
+		Description:        "Tracks comments every 15 minutes",
+		ScheduleExpression: "rate(15 minutes)",
+	}
+	commentsFn.Permissions = append(commentsFn.Permissions, commentsPermission)
+	lambdaFunctions = append(lambdaFunctions, commentsFn)
+
+	deliveriesFn := sparta.HandleAWSLambda("Manage Deliveries", http.HandlerFunc(manageDeliveries), sparta.IAMRoleDefinition{})
+	deliveriesFn.Options = createLambdaOptions("Lists and re-drives outbox deliveries", 150, envMap)
+	lambdaFunctions = append(lambdaFunctions, deliveriesFn)
+
+	previewFn := sparta.HandleAWSLambda("Preview Templates", http.HandlerFunc(previewTemplates), sparta.IAMRoleDefinition{})
+	previewFn.Options = createLambdaOptions("Renders message templates against a stored article", 10, envMap)
+	lambdaFunctions = append(lambdaFunctions, previewFn)
+
+	unsubscribeFn := sparta.HandleAWSLambda("Unsubscribe", http.HandlerFunc(unsubscribe), sparta.IAMRoleDefinition{})
+	unsubscribeFn.Options = createLambdaOptions("Removes email subscriptions from signed unsubscribe links", 10, envMap)
+	lambdaFunctions = append(lambdaFunctions, unsubscribeFn)
+
+	pushFn := sparta.HandleAWSLambda("Web Push", http.HandlerFunc(managePush), sparta.IAMRoleDefinition{})
+	pushFn.Options = createLambdaOptions("Stores browser push subscriptions", 10, envMap)
+	lambdaFunctions = append(lambdaFunctions, pushFn)
+
+	snapshotsFn := sparta.HandleAWSLambda("Article Snapshots", http.HandlerFunc(articleSnapshots), sparta.IAMRoleDefinition{})
+	snapshotsFn.Options = createLambdaOptions("Lists and downloads archived snapshots of an article", 30, envMap)
+	lambdaFunctions = append(lambdaFunctions, snapshotsFn)
+
+	interactionFn := sparta.HandleAWSLambda("Discord Interaction", http.HandlerFunc(handleInteraction), sparta.IAMRoleDefinition{})
+	interactionFn.Options = createLambdaOptions("Answers Discord slash commands", 10, envMap)
+	lambdaFunctions = append(lambdaFunctions, interactionFn)
+
 	if api != nil {
 		scrapeAllRes, _ := api.NewResource("/scrape", scrapeAllFn)
-		_, err := scrapeAllRes.NewMethod(http.MethodPost, http.StatusOK)
+		_, err := scrapeAllRes.NewMethod(http.MethodPost, http.StatusOK, http.StatusInternalServerError)
 		if err != nil {
 			panic("Failed to create /scrape resource")
 		}
@@ -385,6 +531,64 @@ func spartaLambdaFunctions(api *sparta.API) []*sparta.LambdaAWSInfo {
 		if err != nil {
 			panic("Failed to create /get resource")
 		}
+
+		interactionRes, _ := api.NewResource("/interactions", interactionFn)
+		_, err = interactionRes.NewMethod(http.MethodPost, http.StatusOK, http.StatusUnauthorized)
+		if err != nil {
+			panic("Failed to create /interactions resource")
+		}
+
+		subscriptionsRes, _ := api.NewResource("/subscriptions", subscriptionsFn)
+		for _, m := range []string{http.MethodGet, http.MethodPost, http.MethodDelete} {
+			_, err = subscriptionsRes.NewMethod(m, http.StatusOK, http.StatusUnauthorized)
+			if err != nil {
+				panic("Failed to create /subscriptions resource")
+			}
+		}
+
+		deliveriesRes, _ := api.NewResource("/deliveries", deliveriesFn)
+		for _, m := range []string{http.MethodGet, http.MethodPost} {
+			_, err = deliveriesRes.NewMethod(m, http.StatusOK, http.StatusUnauthorized)
+			if err != nil {
+				panic("Failed to create /deliveries resource")
+			}
+		}
+
+		previewRes, _ := api.NewResource("/subscriptions/preview", previewFn)
+		_, err = previewRes.NewMethod(http.MethodPost, http.StatusOK, http.StatusUnauthorized)
+		if err != nil {
+			panic("Failed to create /subscriptions/preview resource")
+		}
+
+		unsubscribeRes, _ := api.NewResource("/unsubscribe", unsubscribeFn)
+		for _, m := range []string{http.MethodGet, http.MethodPost} {
+			_, err = unsubscribeRes.NewMethod(m, http.StatusOK, http.StatusNotFound)
+			if err != nil {
+				panic("Failed to create /unsubscribe resource")
+			}
+		}
+
+		pushRes, _ := api.NewResource("/push", pushFn)
+		for _, m := range []string{http.MethodGet, http.MethodPost, http.MethodDelete, http.MethodOptions} {
+			_, err = pushRes.NewMethod(m, http.StatusOK, http.StatusCreated, http.StatusNoContent, http.StatusBadRequest,
+				http.StatusNotFound, http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusServiceUnavailable)
+			if err != nil {
+				panic("Failed to create /push resource")
+			}
+		}
+
+		snapshotsRes, _ := api.NewResource("/get/{id}/snapshots", snapshotsFn)
+		_, err = snapshotsRes.NewMethod(http.MethodGet, http.StatusOK, http.StatusBadRequest, http.StatusInternalServerError)
+		if err != nil {
+			panic("Failed to create /get/{id}/snapshots resource")
+		}
+
+		snapshotRes, _ := api.NewResource("/get/{id}/snapshots/{revision}", snapshotsFn)
+		_, err = snapshotRes.NewMethod(http.MethodGet, http.StatusOK, http.StatusBadRequest, http.StatusNotFound,
+			http.StatusInternalServerError)
+		if err != nil {
+			panic("Failed to create /get/{id}/snapshots/{revision} resource")
+		}
 	}
 
 	return lambdaFunctions
@@ -394,6 +598,64 @@ func main() {
 	apiStage := sparta.NewStage("v1")
 	apiGateway := sparta.NewAPIGateway("KingsRaidCrawler", apiStage)
 
+	// requests identify the crawler and are spaced out, and the cafe is only
+	// asked for pages that changed since the last scrape
+	fetcher := crawler.NewHTTPFetcher(crawler.DefaultUserAgent, crawler.DefaultInterval)
+	if ua := os.Getenv(envCrawlerUserAgent); ua != "" {
+		fetcher.UserAgent = ua
+	}
+	if d, err := time.ParseDuration(os.Getenv(envCrawlerInterval)); err == nil {
+		fetcher.Limiter.Interval = d
+	}
+	crawler.Default.Fetcher = fetcher
+	crawler.Default.Cache = httpCache{}
+
+	// images and snapshots are stored in S3, or in a directory when running locally.
+	// Images in a directory are only mirrored when it is served at MIRROR_BASE_URL,
+	// as their URLs go out in notifications.
+	if bucket := os.Getenv(envMirrorBucket); bucket != "" {
+		imageMirror = mirror.New(mirror.NewS3Store(bucket, os.Getenv(envMirrorBaseURL)))
+	} else if dir := os.Getenv(envMirrorDir); dir != "" {
+		if baseURL := os.Getenv(envMirrorBaseURL); baseURL != "" {
+			imageMirror = mirror.New(&mirror.LocalStore{Dir: dir, BaseURL: baseURL})
+		} else {
+			logrus.Warn("Images are not mirrored without " + envMirrorBaseURL)
+		}
+	}
+	// snapshots are only served through /get/{id}/snapshots, never publicly
+	if bucket := os.Getenv(envSnapshotBucket); bucket != "" {
+		snapshotStore = mirror.NewPrivateS3Store(bucket)
+	} else if dir := os.Getenv(envSnapshotDir); dir != "" {
+		snapshotStore = &mirror.LocalStore{Dir: dir}
+	}
+
+	// comments are read from the cafe's JSON API, which has no HTML fallback
+	if commentsURL := os.Getenv(envCafeCommentsURL); commentsURL != "" {
+		commentSource = crawler.NewCommentSource(commentsURL, fetcher, strings.Split(os.Getenv(envStaffAccounts), ","))
+	}
+
+	// rules in S3 are fetched again every few minutes, so they can change
+	// without a redeploy; a file is only useful locally as the package is read-only
+	if path := os.Getenv(envCrawlerRules); strings.HasPrefix(path, "s3://") {
+		bucket, key := splitS3URL(path)
+		store := mirror.NewS3Store(bucket, "")
+		crawler.Default.Rules = crawler.NewRulesObject(path, func(ctx context.Context) ([]byte, error) {
+			return store.Get(ctx, key)
+		})
+	} else if path != "" {
+		crawler.Default.Rules = crawler.NewRulesFile(path)
+	}
+	// the JSON listing is preferred, falling back to the HTML when its shape changes
+	if apiURL := os.Getenv(envCafeAPIURL); apiURL != "" {
+		crawler.DefaultSource = crawler.FallbackSource{Sources: []crawler.Source{
+			&crawler.JSONSource{URLFormat: apiURL, Fetcher: fetcher, Cache: httpCache{}},
+			crawler.Default,
+		}}
+	}
+
+	sparta.CommandLineOptions.Root.AddCommand(subscribersCommand(), vapidCommand(), fixturesCommand(), testSelectorsCommand(),
+		scrapeCommand(), backfillCommand(), listCommand(), showCommand(), notifyCommand(), exportCommand())
+
 	sparta.Main("KingsRaidCrawlerStack",
 		"Kings Raid Crawler Core Functionality",
 		spartaLambdaFunctions(apiGateway),
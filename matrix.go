@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/xeia/Kings-Raid-Crawler/crawler"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+const (
+	matrixClientAPI = "/_matrix/client/r0"
+	matrixMediaAPI  = "/_matrix/media/r0"
+
+	// maximum size of a thumbnail uploaded to the media repository
+	maxMatrixThumbnail = 5 << 20
+	// events are limited to 64KB once encoded, including the fields added by
+	// the homeserver, so the content is kept below that with some room to spare
+	maxMatrixContent = 60 << 10
+
+	matrixThumbErr = "thumbnail too large"
+)
+
+var matrixClient = &http.Client{Timeout: webhookTimeout}
+
+// notifyMatrix posts new and edited articles to the subscriber's matrix room
+func notifyMatrix(sub models.Subscription, events []models.ArticleEvent, logger *logrus.Logger) []error {
+	errs := make([]error, len(events))
+	for i, e := range events {
+		if e.Type != models.ArticleRemoved {
+			errs[i] = sendMatrixArticle(sub, e, logger)
+		}
+	}
+	return errs
+}
+
+// sendMatrixArticle sends the article as an HTML message, followed by its
+// thumbnail if enabled. Transaction IDs are derived from the delivery and its
+// replay, so the homeserver discards events re-sent by a retried delivery
+// but not those of a replay.
+func sendMatrixArticle(sub models.Subscription, e models.ArticleEvent, logger *logrus.Logger) error {
+	embed, err := renderEmbedFor(sub.Templates, e.Article, 1, crawler.PlainText)
+	if err != nil {
+		return err
+	}
+
+	// the article's body is already HTML, so it is sent as is unless templated
+	var bodyHTML string
+	if sub.Templates.Description == "" {
+		bodyHTML = e.Article.BodyHTML
+	}
+
+	id := deliveryID(e, sub)
+	if e.Replay > 0 {
+		id += fmt.Sprintf(":replay%d", e.Replay)
+	}
+	txnID := matrixTxnID(id)
+	err = sendMatrixEvent(sub, txnID, buildMatrixMessage(embed, e.Article, bodyHTML))
+	if err != nil {
+		return err
+	}
+
+	if !sub.Thumbnails || e.Article.ImgURL == "" {
+		return nil
+	}
+
+	// the article was delivered, so a missing thumbnail is not worth a retry
+	img, err := uploadMatrixThumbnail(sub, e.Article)
+	if err == nil {
+		err = sendMatrixEvent(sub, txnID+"-thumb", img)
+	}
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"SubscriptionID": sub.ID,
+			"ArticleID":      e.Article.ID,
+		}).Warn("Matrix Error Thumbnail :", err.Error())
+	}
+	return nil
+}
+
+// buildMatrixMessage formats the embed as an m.text message with an HTML body,
+// using bodyHTML as the formatted description if given. Messages too large
+// once encoded fall back to the escaped description, shortened until they fit.
+func buildMatrixMessage(embed models.DiscordEmbed, a models.Article, bodyHTML string) models.MatrixMessage {
+	msg := formatMatrixMessage(embed, a, bodyHTML)
+	if bodyHTML != "" && matrixContentSize(msg) > maxMatrixContent {
+		msg = formatMatrixMessage(embed, a, "")
+	}
+	for n := len([]rune(embed.Description)); n > 0 && matrixContentSize(msg) > maxMatrixContent; {
+		n /= 2
+		embed.Description = truncate(embed.Description, n)
+		msg = formatMatrixMessage(embed, a, "")
+	}
+	return msg
+}
+
+// matrixContentSize returns the size of the message as sent, where < > and &
+// are escaped by encoding/json
+func matrixContentSize(msg models.MatrixMessage) int {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return 0
+	}
+	return len(b)
+}
+
+// formatMatrixMessage formats the plain and HTML bodies of the message
+func formatMatrixMessage(embed models.DiscordEmbed, a models.Article, bodyHTML string) models.MatrixMessage {
+	plain := []string{embed.Title}
+	formatted := []string{fmt.Sprintf(`<h4><a href="%s">%s</a></h4>`,
+		html.EscapeString(embed.URL), html.EscapeString(embed.Title))}
+
+	if embed.Description != "" {
+		plain = append(plain, embed.Description)
+		if bodyHTML != "" {
+			formatted = append(formatted, bodyHTML)
+		} else {
+			formatted = append(formatted, "<p>"+html.EscapeString(embed.Description)+"</p>")
+		}
+	}
+
+	footer := a.Type.String()
+	if embed.Footer.Text != "" {
+		footer += " · " + embed.Footer.Text
+	}
+	plain = append(plain, footer, embed.URL)
+	formatted = append(formatted, "<p><em>"+html.EscapeString(footer)+"</em></p>")
+
+	return models.MatrixMessage{
+		MsgType:       models.MatrixText,
+		Body:          strings.Join(plain, "\n"),
+		Format:        models.MatrixHTMLFormat,
+		FormattedBody: strings.Join(formatted, ""),
+	}
+}
+
+// sendMatrixEvent sends an m.room.message event to the subscriber's room
+func sendMatrixEvent(sub models.Subscription, txnID string, msg models.MatrixMessage) error {
+	u := strings.TrimRight(sub.WebhookURL, "/") + matrixClientAPI + "/rooms/" +
+		url.PathEscape(sub.RoomID) + "/send/m.room.message/" + url.PathEscape(txnID)
+
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	var resp models.MatrixSendResponse
+	return doMatrixRequest(http.MethodPut, u, sub.Token, "application/json", bytes.NewReader(b), &resp)
+}
+
+// uploadMatrixThumbnail mirrors the article's thumbnail to the homeserver's media repository
+func uploadMatrixThumbnail(sub models.Subscription, a models.Article) (models.MatrixMessage, error) {
+	var msg models.MatrixMessage
+	resp, err := matrixClient.Get(a.ImgURL)
+	if err != nil {
+		return msg, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return msg, fmt.Errorf("Response code received: %d", resp.StatusCode)
+	}
+
+	img, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxMatrixThumbnail+1))
+	if err != nil {
+		return msg, err
+	}
+	if len(img) > maxMatrixThumbnail {
+		return msg, fmt.Errorf(matrixThumbErr)
+	}
+
+	mimeType := resp.Header.Get("Content-Type")
+	if mimeType == "" {
+		mimeType = http.DetectContentType(img)
+	}
+	name := path.Base(a.ImgURL)
+	u := strings.TrimRight(sub.WebhookURL, "/") + matrixMediaAPI + "/upload?filename=" + url.QueryEscape(name)
+
+	var upload models.MatrixUploadResponse
+	err = doMatrixRequest(http.MethodPost, u, sub.Token, mimeType, bytes.NewReader(img), &upload)
+	if err != nil {
+		return msg, err
+	}
+
+	return models.MatrixMessage{
+		MsgType: models.MatrixImage,
+		Body:    name,
+		URL:     upload.ContentURI,
+		Info:    &models.MatrixImageInfo{MimeType: mimeType, Size: len(img)},
+	}, nil
+}
+
+// doMatrixRequest sends an authenticated request and decodes the response into out
+func doMatrixRequest(method string, u string, token string, contentType string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(headerAuthorization, bearerPrefix+token)
+
+	resp, err := matrixClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var merr models.MatrixError
+		json.NewDecoder(resp.Body).Decode(&merr)
+		return fmt.Errorf("Response code received: %d %s %s", resp.StatusCode, merr.ErrCode, merr.Error)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// matrixTxnID derives a transaction ID from the delivery ID
+func matrixTxnID(id string) string {
+	h := sha1.New()
+	io.WriteString(h, id)
+	return "kr" + hex.EncodeToString(h.Sum(nil))
+}
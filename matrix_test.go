@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/Sirupsen/logrus"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const testMatrixToken = "syt_test"
+
+// homeserver is a stand-in for the client-server and media APIs of a matrix
+// homeserver. Like a real one, it only keeps the first event of each transaction.
+type homeserver struct {
+	mu      sync.Mutex
+	events  map[string]models.MatrixMessage
+	order   []string
+	uploads int
+	fail    map[string]bool // event bodies containing one of these are rejected
+}
+
+func newHomeserver() *homeserver {
+	return &homeserver{events: make(map[string]models.MatrixMessage), fail: make(map[string]bool)}
+}
+
+func (h *homeserver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/thumb.png" {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG\r\n\x1a\n"))
+		return
+	}
+	if r.Header.Get(headerAuthorization) != bearerPrefix+testMatrixToken {
+		matrixTestError(w, http.StatusUnauthorized, "M_UNKNOWN_TOKEN")
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == matrixMediaAPI+"/upload":
+		h.uploads++
+		json.NewEncoder(w).Encode(models.MatrixUploadResponse{ContentURI: "mxc://localhost/thumb"})
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, matrixClientAPI+"/rooms/!room:localhost/send/m.room.message/"):
+		txnID := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		b, _ := ioutil.ReadAll(r.Body)
+		if len(b) > 65536 {
+			matrixTestError(w, http.StatusRequestEntityTooLarge, "M_TOO_LARGE")
+			return
+		}
+		var msg models.MatrixMessage
+		if err := json.Unmarshal(b, &msg); err != nil {
+			matrixTestError(w, http.StatusBadRequest, "M_NOT_JSON")
+			return
+		}
+		for s := range h.fail {
+			if strings.Contains(msg.Body, s) {
+				matrixTestError(w, http.StatusInternalServerError, "M_UNKNOWN")
+				return
+			}
+		}
+		if _, ok := h.events[txnID]; !ok {
+			h.events[txnID] = msg
+			h.order = append(h.order, txnID)
+		}
+		json.NewEncoder(w).Encode(models.MatrixSendResponse{EventID: "$" + txnID})
+	default:
+		matrixTestError(w, http.StatusNotFound, "M_UNRECOGNIZED")
+	}
+}
+
+func matrixTestError(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(models.MatrixError{ErrCode: code, Error: code})
+}
+
+func newMatrixTestSubscription(t *testing.T, srvURL string) models.Subscription {
+	t.Helper()
+	sub := models.Subscription{
+		ID:         "matrix",
+		Kind:       models.SubscriptionMatrix,
+		WebhookURL: srvURL,
+		RoomID:     "!room:localhost",
+		Token:      testMatrixToken,
+	}
+	if err := validateSubscription(sub); err != nil {
+		t.Fatalf("stand-in homeserver rejected: %v", err)
+	}
+	return sub
+}
+
+func TestNotifyMatrix(t *testing.T) {
+	hs := newHomeserver()
+	srv := httptest.NewServer(hs)
+	defer srv.Close()
+
+	sub := newMatrixTestSubscription(t, srv.URL)
+	sub.Thumbnails = true
+	events := []models.ArticleEvent{
+		{Type: models.ArticleNew, Article: models.Article{ID: 1, Type: models.NOTICE, Title: "Maintenance <b>", Desc: "Servers are down", ImgURL: srv.URL + "/thumb.png"}},
+		{Type: models.ArticleRemoved, Article: models.Article{ID: 2, Type: models.NOTICE, Title: "Removed"}},
+	}
+	for i, err := range notifyMatrix(sub, events, logrus.New()) {
+		if err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+	}
+
+	if len(hs.order) != 2 || hs.uploads != 1 {
+		t.Fatalf("got %d events and %d uploads, want the article and its thumbnail", len(hs.order), hs.uploads)
+	}
+	msg := hs.events[hs.order[0]]
+	if msg.MsgType != models.MatrixText || msg.Format != models.MatrixHTMLFormat {
+		t.Errorf("message = %+v", msg)
+	}
+	if !strings.Contains(msg.FormattedBody, "Maintenance &lt;b&gt;") {
+		t.Errorf("title is not escaped in %q", msg.FormattedBody)
+	}
+	if img := hs.events[hs.order[1]]; img.MsgType != models.MatrixImage || img.URL != "mxc://localhost/thumb" {
+		t.Errorf("thumbnail = %+v", img)
+	}
+
+	// a retried delivery reuses its transaction, so the homeserver keeps a single event
+	notifyMatrix(sub, events[:1], logrus.New())
+	if len(hs.order) != 2 {
+		t.Errorf("retry created %d events", len(hs.order)-2)
+	}
+}
+
+func TestNotifyMatrixPerEventErrors(t *testing.T) {
+	hs := newHomeserver()
+	hs.fail["Broken"] = true
+	srv := httptest.NewServer(hs)
+	defer srv.Close()
+
+	sub := newMatrixTestSubscription(t, srv.URL)
+	events := []models.ArticleEvent{
+		{Type: models.ArticleNew, Article: models.Article{ID: 1, Type: models.EVENTS, Title: "Broken"}},
+		{Type: models.ArticleNew, Article: models.Article{ID: 2, Type: models.EVENTS, Title: "Fine"}},
+	}
+	errs := notifyMatrix(sub, events, logrus.New())
+	if errs[0] == nil || !strings.Contains(errs[0].Error(), "M_UNKNOWN") {
+		t.Errorf("failed event: %v", errs[0])
+	}
+	if errs[1] != nil {
+		t.Errorf("delivered event: %v", errs[1])
+	}
+
+	sub.Token = "wrong"
+	if err := notifyMatrix(sub, events[1:], logrus.New())[0]; err == nil || !strings.Contains(err.Error(), "M_UNKNOWN_TOKEN") {
+		t.Errorf("bad token: %v", err)
+	}
+}
+
+func TestValidateSubscriptionLoopback(t *testing.T) {
+	tests := []struct {
+		url string
+		ok  bool
+	}{
+		{"https://matrix.example.org", true},
+		{"http://127.0.0.1:8008", true},
+		{"http://[::1]:8008", true},
+		{"http://localhost:8008", true},
+		{"http://matrix.example.org", false},
+		{"http://10.0.0.1:8008", false},
+	}
+	for _, tt := range tests {
+		sub := models.Subscription{Kind: models.SubscriptionMatrix, WebhookURL: tt.url, RoomID: "!room:localhost", Token: testMatrixToken}
+		if err := validateSubscription(sub); (err == nil) != tt.ok {
+			t.Errorf("validateSubscription(%s) = %v, want ok = %v", tt.url, err, tt.ok)
+		}
+	}
+}
+
+func TestNotifyMatrixLargeBody(t *testing.T) {
+	hs := newHomeserver()
+	srv := httptest.NewServer(hs)
+	defer srv.Close()
+	sub := newMatrixTestSubscription(t, srv.URL)
+
+	// markup and entities are escaped by encoding/json, growing up to six times
+	body := strings.Repeat("<p><b>&lt;&gt;</b></p>", 1400)
+	e := models.ArticleEvent{ID: "large", Type: models.ArticleNew, Article: models.Article{
+		ID:       1,
+		Type:     models.NOTICE,
+		Title:    "Large",
+		Desc:     strings.Repeat("<>", 4000),
+		BodyHTML: body,
+	}}
+	errs := notifyMatrix(sub, []models.ArticleEvent{e}, logrus.New())
+	if errs[0] != nil {
+		t.Fatalf("large article: %v", errs[0])
+	}
+
+	msg := hs.events[hs.order[0]]
+	if strings.Contains(msg.FormattedBody, body) {
+		t.Error("body sent although too large once encoded")
+	}
+	if !strings.Contains(msg.Body, "Large") {
+		t.Errorf("body: %.80q", msg.Body)
+	}
+}
+
+func TestBuildMatrixMessageKeepsBody(t *testing.T) {
+	embed := models.DiscordEmbed{Title: "Small", Description: "text"}
+	msg := buildMatrixMessage(embed, models.Article{Type: models.NOTICE}, "<p>text</p>")
+	if !strings.Contains(msg.FormattedBody, "<p>text</p>") {
+		t.Errorf("formatted body: %q", msg.FormattedBody)
+	}
+}
+
+func TestNotifyMatrixReplay(t *testing.T) {
+	hs := newHomeserver()
+	srv := httptest.NewServer(hs)
+	defer srv.Close()
+	sub := newMatrixTestSubscription(t, srv.URL)
+
+	e := models.ArticleEvent{ID: "replay", Type: models.ArticleNew, Article: models.Article{ID: 1, Type: models.NOTICE, Title: "Replayed"}}
+	// a retry is discarded by the homeserver, a replay is not
+	for _, replay := range []int{0, 0, 1} {
+		e.Replay = replay
+		if errs := notifyMatrix(sub, []models.ArticleEvent{e}, logrus.New()); errs[0] != nil {
+			t.Fatalf("replay %d: %v", replay, errs[0])
+		}
+	}
+	if len(hs.order) != 2 {
+		t.Errorf("got %d event(s), want 2", len(hs.order))
+	}
+}
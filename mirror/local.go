@@ -0,0 +1,47 @@
+package mirror
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStore stores files in Dir, served at BaseURL by another server.
+// Without BaseURL the returned URLs are file:// URLs, only usable locally.
+type LocalStore struct {
+	Dir     string
+	BaseURL string
+}
+
+// Put writes the file, returning its URL under BaseURL
+func (s *LocalStore) Put(ctx context.Context, key string, contentType string, data []byte) (string, error) {
+	p := filepath.Join(s.Dir, filepath.FromSlash(key))
+	err := os.MkdirAll(filepath.Dir(p), 0755)
+	if err != nil {
+		return "", err
+	}
+	err = ioutil.WriteFile(p, data, 0644)
+	if err != nil {
+		return "", err
+	}
+
+	if s.BaseURL == "" {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return "", err
+		}
+		return "file://" + filepath.ToSlash(abs), nil
+	}
+	return strings.TrimRight(s.BaseURL, "/") + "/" + key, nil
+}
+
+// Get reads the file stored under key
+func (s *LocalStore) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(s.Dir, filepath.FromSlash(key)))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
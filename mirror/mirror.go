@@ -0,0 +1,100 @@
+// Package mirror copies article images to a store we control, since the
+// cafe's CDN URLs expire or get replaced
+package mirror
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	fetchTimeout = 30 * time.Second
+	// maximum size of a mirrored image
+	maxImageSize = 10 << 20
+
+	imagePrefix = "images/"
+)
+
+var imageExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// ErrNotFound is returned when no file is stored under a key
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore stores mirrored files
+type BlobStore interface {
+	// Put stores data under key and returns the URL it is served from
+	Put(ctx context.Context, key string, contentType string, data []byte) (string, error)
+	// Get returns the data stored under key
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// Mirror downloads images and stores them in Store under a key derived from
+// their content, so an image is stored once however often it is mirrored
+type Mirror struct {
+	Store  BlobStore
+	Client *http.Client
+}
+
+// New returns a mirror storing images in store
+func New(store BlobStore) *Mirror {
+	return &Mirror{Store: store, Client: &http.Client{Timeout: fetchTimeout}}
+}
+
+// Image mirrors the image at src and returns its mirrored URL
+func (m *Mirror) Image(ctx context.Context, src string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, src, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := m.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Response code received: %d %s", resp.StatusCode, src)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxImageSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxImageSize {
+		return "", fmt.Errorf("image too large: %s", src)
+	}
+
+	// the CDN does not always send a content type
+	contentType := http.DetectContentType(data)
+	if !strings.HasPrefix(contentType, "image/") {
+		contentType = strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+		return "", fmt.Errorf("not an image: %s %s", contentType, src)
+	}
+
+	return m.Store.Put(ctx, imageKey(data, contentType, src), contentType, data)
+}
+
+// imageKey returns the key of an image, e.g. images/<sha256>.png
+func imageKey(data []byte, contentType string, src string) string {
+	sum := sha256.Sum256(data)
+	ext, ok := imageExtensions[contentType]
+	if !ok {
+		ext = strings.ToLower(path.Ext(strings.Split(src, "?")[0]))
+	}
+	return imagePrefix + hex.EncodeToString(sum[:]) + ext
+}
@@ -0,0 +1,74 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io/ioutil"
+	"strings"
+)
+
+const (
+	// mirrored images are stored under content-hashed keys, so their content never changes
+	immutableCacheControl = "public, max-age=31536000, immutable"
+	// files of a private store must not be kept by shared caches
+	privateCacheControl = "private, no-cache"
+)
+
+// S3Store stores files in an S3 bucket, served at BaseURL
+type S3Store struct {
+	Bucket       string
+	BaseURL      string
+	CacheControl string
+	Client       *s3.S3
+}
+
+// NewS3Store returns a store for the bucket. Files are served from the
+// bucket's public endpoint unless baseURL, e.g. a CDN, is given.
+func NewS3Store(bucket string, baseURL string) *S3Store {
+	if baseURL == "" {
+		baseURL = "https://" + bucket + ".s3.amazonaws.com"
+	}
+	sess := session.Must(session.NewSession())
+	return &S3Store{Bucket: bucket, BaseURL: baseURL, CacheControl: immutableCacheControl, Client: s3.New(sess)}
+}
+
+// NewPrivateS3Store returns a store for a bucket that is not publicly
+// readable, whose files are only read back through Get
+func NewPrivateS3Store(bucket string) *S3Store {
+	sess := session.Must(session.NewSession())
+	return &S3Store{Bucket: bucket, BaseURL: "s3://" + bucket, CacheControl: privateCacheControl, Client: s3.New(sess)}
+}
+
+// Put uploads the file, returning its URL under BaseURL
+func (s *S3Store) Put(ctx context.Context, key string, contentType string, data []byte) (string, error) {
+	_, err := s.Client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:       aws.String(s.Bucket),
+		Key:          aws.String(key),
+		Body:         bytes.NewReader(data),
+		ContentType:  aws.String(contentType),
+		CacheControl: aws.String(s.CacheControl),
+	})
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(s.BaseURL, "/") + "/" + key, nil
+}
+
+// Get downloads the file stored under key
+func (s *S3Store) Get(ctx context.Context, key string) ([]byte, error) {
+	out, err := s.Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return ioutil.ReadAll(out.Body)
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// ArticleEventType represents what happened to an article
+type ArticleEventType string
+
+// Enum for ArticleEventType
+const (
+	ArticleNew     ArticleEventType = "article.new"
+	ArticleEdited  ArticleEventType = "article.edited"
+	ArticleRemoved ArticleEventType = "article.removed"
+	StaffReply     ArticleEventType = "article.staff_reply"
+)
+
+// ArticleEvent is a change to an article read from the DB stream.
+// Generic webhook subscribers receive it as a WebhookEvent.
+type ArticleEvent struct {
+	ID        string           `json:"id"`
+	Type      ArticleEventType `json:"type"`
+	Timestamp time.Time        `json:"timestamp"`
+	Article   Article          `json:"article"`
+	Comment   *Comment         `json:"comment,omitempty"` // set on staff replies
+
+	// incremented each time the event is replayed, so notifiers deduplicating
+	// by delivery can tell a replay from a retry
+	Replay int `dynamo:"replay,omitempty" json:"-"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// ArticleStats table const
+const (
+	ArticleStatsTable        = "kr-article-stats"
+	ArticleStatsArticleIDCol = "article-id"
+	ArticleStatsViewsCol     = "views"
+	ArticleStatsUpdatedOnCol = "updated-on"
+)
+
+// ArticleStats holds the view and reaction counts of an article. They are
+// kept apart from the article so updating them does not emit article events.
+type ArticleStats struct {
+	ArticleID int       `dynamo:"article-id" json:"article_id"` // primary partition key
+	Views     int       `dynamo:"views" json:"views"`
+	Likes     int       `dynamo:"likes" json:"likes"`
+	Comments  int       `dynamo:"comments" json:"comments"`
+	UpdatedOn time.Time `dynamo:"updated-on" json:"updated_on"`
+
+	// set by the first comment tracking pass over the article
+	TrackedSince time.Time `dynamo:"tracked-since" json:"tracked_since"`
+}
@@ -12,23 +12,59 @@ const (
 	PATCHNOTES
 )
 
+// String returns the display name of the ArticleType
+func (t ArticleType) String() string {
+	switch t {
+	case NOTICE:
+		return "Notice"
+	case EVENTS:
+		return "Events"
+	case PATCHNOTES:
+		return "Patch Notes"
+	}
+	return "Unknown"
+}
+
 // Article table const
 const (
-	ArticleTable     = "kr-articles"
-	ArticleTypeCol   = "article-type"
-	ArticleIDCol     = "article-id"
-	ArticleTitleCol  = "title"
-	ArticleDescCol   = "description"
-	ArticleImgURLCol = "thumb-url"
+	ArticleTable        = "kr-articles"
+	ArticleTypeCol      = "article-type"
+	ArticleIDCol        = "article-id"
+	ArticleTitleCol     = "title"
+	ArticleDescCol      = "description"
+	ArticleImgURLCol    = "thumb-url"
+	ArticleRegionCol    = "article-region"
+	ArticleRevisionCol  = "revision"
+	ArticleAuthorCol    = "article-author"
+	ArticleCreatedOnCol = "created-on"
 )
 
-// Article representing a published article on PLUG Cafe
+// Article representing a published article on PLUG Cafe. The original
+// fields keep the JSON names the query endpoints have always returned.
 type Article struct {
-	ID         int         `dynamo:"article-id",json:"article_id"`     // primary partition key
-	Type       ArticleType `dynamo:"article-type",json:"article_type"` // primary sort key
-	Title      string      `dynamo:"article-title",json:"article_title"`
-	Desc       string      `dynamo:"article-description",json:"article_description"`
-	ImgURL     string      `dynamo:"article-thumb-url",json:"article_thumb_url"`
-	CreatedOn  time.Time   `dynamo:"created-on",json:"-"`
-	ModifiedOn time.Time   `dynamo:"modified-on",json:"-"`
+	ID         int         `dynamo:"article-id" json:"ID"`     // primary partition key
+	Type       ArticleType `dynamo:"article-type" json:"Type"` // primary sort key
+	Title      string      `dynamo:"article-title" json:"Title"`
+	Desc       string      `dynamo:"article-description" json:"Desc"`
+	ImgURL     string      `dynamo:"article-thumb-url" json:"ImgURL"` // mirrored copy when mirroring is enabled
+	Region     string      `dynamo:"article-region" json:"article_region"`
+	Revision   int         `dynamo:"revision" json:"revision"` // incremented on each detected edit
+	CreatedOn  time.Time   `dynamo:"created-on" json:"CreatedOn"`
+	ModifiedOn time.Time   `dynamo:"modified-on" json:"ModifiedOn"`
+
+	// only known when scraped from the cafe's JSON listing. Views change
+	// constantly, so they are stored in the ArticleStats table instead.
+	Author      string     `dynamo:"article-author" json:"article_author,omitempty"`
+	Views       int        `dynamo:"-" json:"article_views,omitempty"`
+	PublishedOn *time.Time `dynamo:"published-on,omitempty" json:"article_published_on,omitempty"`
+
+	// body of the article's page, when the rules select one
+	Body     string `dynamo:"article-body" json:"article_body,omitempty"` // markdown
+	BodyHTML string `dynamo:"article-body-html" json:"-"`                 // sanitized, rendered per notifier
+
+	// the cafe's URL of the thumbnail, set once ImgURL was mirrored
+	OriginalImgURL string `dynamo:"article-original-thumb-url" json:"article_original_thumb_url,omitempty"`
+
+	// set while notifying an edited article, never stored
+	Edited bool `dynamo:"-" json:"-"`
 }
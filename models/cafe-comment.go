@@ -0,0 +1,27 @@
+package models
+
+import "encoding/json"
+
+// CafeCommentListing is the response of the cafe's JSON comment listing of a post
+type CafeCommentListing struct {
+	Post struct {
+		ViewCount    int `json:"viewCount"`
+		LikeCount    int `json:"likeCount"`
+		CommentCount int `json:"commentCount"`
+	} `json:"post"`
+	Comments []CafeComment `json:"comments"`
+}
+
+// CafeComment is a comment as returned by the cafe, with its replies nested
+type CafeComment struct {
+	ID        int    `json:"commentId"`
+	Content   string `json:"content"`
+	LikeCount int    `json:"likeCount"`
+	CreatedAt int64  `json:"createdAt"` // unix milliseconds
+	Author    struct {
+		MemberID json.Number `json:"memberId"`
+		Nickname string      `json:"nickname"`
+		Staff    bool        `json:"staff"`
+	} `json:"author"`
+	Replies []CafeComment `json:"replies"`
+}
@@ -0,0 +1,19 @@
+package models
+
+// CafePostListing is the response of the cafe's JSON post listing
+type CafePostListing struct {
+	Posts []CafePost `json:"posts"`
+}
+
+// CafePost is a post as returned by the cafe's JSON post listing
+type CafePost struct {
+	ID           int    `json:"postId"`
+	Title        string `json:"title"`
+	Summary      string `json:"summary"`
+	ThumbnailURL string `json:"thumbnailUrl"`
+	ViewCount    int    `json:"viewCount"`
+	CreatedAt    int64  `json:"createdAt"` // unix milliseconds
+	Author       struct {
+		Nickname string `json:"nickname"`
+	} `json:"author"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Comment table const
+const (
+	CommentTable        = "kr-comments"
+	CommentArticleIDCol = "article-id"
+	CommentIDCol        = "comment-id"
+	CommentLikesCol     = "likes"
+	CommentContentCol   = "content"
+)
+
+// Comment is a player or staff comment on a tracked article
+type Comment struct {
+	ArticleID   int       `dynamo:"article-id" json:"article_id"`         // primary partition key
+	ID          int       `dynamo:"comment-id" json:"comment_id"`         // primary sort key
+	ParentID    int       `dynamo:"parent-id" json:"parent_id,omitempty"` // set on replies
+	Author      string    `dynamo:"author" json:"author"`
+	AuthorID    string    `dynamo:"author-id" json:"author_id,omitempty"`
+	Content     string    `dynamo:"content" json:"content"`
+	Likes       int       `dynamo:"likes" json:"likes"`
+	Staff       bool      `dynamo:"staff" json:"staff"` // posted by an official account
+	CreatedOn   time.Time `dynamo:"created-on" json:"created_on"`
+	FirstSeenOn time.Time `dynamo:"first-seen-on" json:"first_seen_on"`
+}
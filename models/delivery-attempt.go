@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// DeliveryAttempt table const
+const (
+	DeliveryAttemptTable   = "kr-delivery-attempts"
+	DeliveryAttemptIDCol   = "delivery-id"
+	DeliveryAttemptTimeCol = "attempted-on"
+)
+
+// DeliveryAttempt records a single attempt at delivering
+// an ArticleEvent to a webhook subscriber
+type DeliveryAttempt struct {
+	DeliveryID     string        `dynamo:"delivery-id" json:"delivery_id"`   // primary partition key
+	AttemptedOn    time.Time     `dynamo:"attempted-on" json:"attempted_on"` // primary sort key
+	Attempt        int           `dynamo:"attempt" json:"attempt"`
+	SubscriptionID string        `dynamo:"subscription-id" json:"subscription_id"`
+	EventID        string        `dynamo:"event-id" json:"event_id"`
+	URL            string        `dynamo:"url" json:"url"`
+	StatusCode     int           `dynamo:"status-code" json:"status_code"`
+	Error          string        `dynamo:"error" json:"error,omitempty"`
+	Duration       time.Duration `dynamo:"duration" json:"duration"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// Delivery table const
+const (
+	DeliveryTable        = "kr-deliveries"
+	DeliveryIDCol        = "delivery-id"
+	DeliveryStatusCol    = "status"
+	DeliveryNextRetryCol = "next-retry"
+)
+
+// DeliveryStatus represents the state of a Delivery in the outbox
+type DeliveryStatus string
+
+// Enum for DeliveryStatus
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryQueued    DeliveryStatus = "queued" // held back until the subscriber's quiet hours end
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryFailed    DeliveryStatus = "failed" // waiting for a retry
+	DeliveryDead      DeliveryStatus = "dead"   // gave up after too many attempts
+)
+
+// Delivery is an ArticleEvent waiting to be, or having been,
+// delivered to a single subscriber
+type Delivery struct {
+	ID             string         `dynamo:"delivery-id" json:"delivery_id"` // primary partition key
+	SubscriptionID string         `dynamo:"subscription-id" json:"subscription_id"`
+	Event          ArticleEvent   `dynamo:"event" json:"event"`
+	Status         DeliveryStatus `dynamo:"status" json:"status"`
+	Attempts       int            `dynamo:"attempts" json:"attempts"`
+	NextRetry      time.Time      `dynamo:"next-retry" json:"next_retry"`
+	LastError      string         `dynamo:"last-error" json:"last_error,omitempty"`
+	CreatedOn      time.Time      `dynamo:"created-on" json:"created_on"`
+	ModifiedOn     time.Time      `dynamo:"modified-on" json:"modified_on"`
+}
@@ -0,0 +1,70 @@
+package models
+
+// DiscordInteractionType represents the kind of interaction sent by Discord
+type DiscordInteractionType int
+
+// Enum for DiscordInteractionType
+const (
+	InteractionPing DiscordInteractionType = 1 + iota
+	InteractionApplicationCommand
+)
+
+// DiscordCallbackType represents the kind of response sent back to Discord
+type DiscordCallbackType int
+
+// Enum for DiscordCallbackType
+const (
+	CallbackPong                     DiscordCallbackType = 1
+	CallbackChannelMessageWithSource DiscordCallbackType = 4
+)
+
+// DiscordOptionType represents the type of an application command option
+type DiscordOptionType int
+
+// Enum for DiscordOptionType
+const (
+	OptionSubCommand DiscordOptionType = 1
+	OptionString     DiscordOptionType = 3
+	OptionInteger    DiscordOptionType = 4
+)
+
+// DiscordMessageFlagEphemeral marks a response as only visible to the invoking user
+const DiscordMessageFlagEphemeral = 1 << 6
+
+// DiscordInteractionOption is an option (or sub command) supplied with a command
+type DiscordInteractionOption struct {
+	Name    string                     `json:"name"`
+	Type    DiscordOptionType          `json:"type"`
+	Value   interface{}                `json:"value,omitempty"`
+	Options []DiscordInteractionOption `json:"options,omitempty"`
+}
+
+// DiscordInteractionData holds the invoked command and its options
+type DiscordInteractionData struct {
+	ID      string                     `json:"id"`
+	Name    string                     `json:"name"`
+	Options []DiscordInteractionOption `json:"options"`
+}
+
+// DiscordInteraction is the payload Discord posts to the interactions endpoint
+type DiscordInteraction struct {
+	ID        string                 `json:"id"`
+	Type      DiscordInteractionType `json:"type"`
+	Data      DiscordInteractionData `json:"data"`
+	GuildID   string                 `json:"guild_id"`
+	ChannelID string                 `json:"channel_id"`
+	Token     string                 `json:"token"`
+}
+
+// DiscordInteractionCallbackData is the message sent in reply to a command
+type DiscordInteractionCallbackData struct {
+	Content string         `json:"content,omitempty"`
+	Embeds  []DiscordEmbed `json:"embeds,omitempty"`
+	Flags   int            `json:"flags,omitempty"`
+}
+
+// DiscordInteractionResponse is the reply to an interaction
+type DiscordInteractionResponse struct {
+	Type DiscordCallbackType             `json:"type"`
+	Data *DiscordInteractionCallbackData `json:"data,omitempty"`
+}
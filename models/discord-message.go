@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// DiscordMessage table const
+const (
+	DiscordMessageTable           = "kr-discord-messages"
+	DiscordMessageSubscriptionCol = "subscription-id"
+	DiscordMessageArticleCol      = "article-id"
+	DiscordMessageEditedCol       = "edited-article-ids"
+)
+
+// DiscordMessage remembers which webhook message an article was posted in
+// so that the message can be edited when the article is revised
+type DiscordMessage struct {
+	SubscriptionID string    `dynamo:"subscription-id"` // primary partition key
+	ArticleID      int       `dynamo:"article-id"`      // primary sort key
+	MessageID      string    `dynamo:"message-id"`
+	ArticleIDs     []int     `dynamo:"article-ids"`                  // every article embedded in the message
+	EditedIDs      []int     `dynamo:"edited-article-ids,omitempty"` // articles whose embed is marked as edited
+	CreatedOn      time.Time `dynamo:"created-on"`
+}
+
+// DiscordMessageResponse is the message returned by discord when posting with ?wait=true
+type DiscordMessageResponse struct {
+	ID        string `json:"id"`
+	ChannelID string `json:"channel_id"`
+}
@@ -44,8 +44,16 @@ type DiscordEmbed struct {
 	Fields      []DiscordField   `json:"fields"`
 }
 
+// DiscordAllowedMentions restricts who can be pinged by a message
+type DiscordAllowedMentions struct {
+	Parse []string `json:"parse"`
+	Roles []string `json:"roles,omitempty"`
+	Users []string `json:"users,omitempty"`
+}
+
 // DiscordHookMessage
 type DiscordHookMessage struct {
-	Content string         `json:"content"`
-	Embeds  []DiscordEmbed `json:"embeds"`
+	Content         string                  `json:"content,omitempty"`
+	Embeds          []DiscordEmbed          `json:"embeds"`
+	AllowedMentions *DiscordAllowedMentions `json:"allowed_mentions,omitempty"`
 }
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// HTTPCache table const
+const (
+	HTTPCacheTable  = "kr-http-cache"
+	HTTPCacheURLCol = "url"
+)
+
+// HTTPCache holds the validators of the last page fetched from a URL,
+// allowing the crawler to send conditional requests
+type HTTPCache struct {
+	URL          string    `dynamo:"url"` // primary partition key
+	ETag         string    `dynamo:"etag"`
+	LastModified string    `dynamo:"last-modified"`
+	UpdatedOn    time.Time `dynamo:"updated-on"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// LayoutAlert table const
+const (
+	LayoutAlertTable   = "kr-layout-alerts"
+	LayoutAlertTypeCol = "article-type"
+)
+
+// LayoutAlert records the last layout alert raised for each category,
+// so the same problems are not reported on every scrape
+type LayoutAlert struct {
+	Type         ArticleType `dynamo:"article-type"` // primary partition key
+	ProblemsHash string      `dynamo:"problems-hash"`
+	AlertedOn    time.Time   `dynamo:"alerted-on"`
+}
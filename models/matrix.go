@@ -0,0 +1,41 @@
+package models
+
+// Matrix message types and formats
+const (
+	MatrixText       = "m.text"
+	MatrixImage      = "m.image"
+	MatrixHTMLFormat = "org.matrix.custom.html"
+)
+
+// MatrixImageInfo describes an uploaded image
+type MatrixImageInfo struct {
+	MimeType string `json:"mimetype,omitempty"`
+	Size     int    `json:"size,omitempty"`
+}
+
+// MatrixMessage is the content of an m.room.message event
+type MatrixMessage struct {
+	MsgType       string           `json:"msgtype"`
+	Body          string           `json:"body"`
+	Format        string           `json:"format,omitempty"`
+	FormattedBody string           `json:"formatted_body,omitempty"`
+	URL           string           `json:"url,omitempty"`
+	Info          *MatrixImageInfo `json:"info,omitempty"`
+}
+
+// MatrixUploadResponse is returned by the media repository after an upload
+type MatrixUploadResponse struct {
+	ContentURI string `json:"content_uri"`
+}
+
+// MatrixSendResponse is returned by the homeserver after sending an event
+type MatrixSendResponse struct {
+	EventID string `json:"event_id"`
+}
+
+// MatrixError is the error body returned by the homeserver
+type MatrixError struct {
+	ErrCode      string `json:"errcode"`
+	Error        string `json:"error"`
+	RetryAfterMs int    `json:"retry_after_ms,omitempty"`
+}
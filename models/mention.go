@@ -0,0 +1,15 @@
+package models
+
+// Mention pings discord roles and users when an article matches its
+// filters. An empty filter matches everything.
+type Mention struct {
+	Types    []ArticleType `dynamo:"article-types" json:"article_types,omitempty"`
+	Keywords []string      `dynamo:"keywords" json:"keywords,omitempty"`
+	Roles    []string      `dynamo:"roles" json:"roles,omitempty"` // role IDs
+	Users    []string      `dynamo:"users" json:"users,omitempty"` // user IDs
+}
+
+// Matches returns true if the article should trigger the mention
+func (m Mention) Matches(a Article) bool {
+	return matchesType(m.Types, a.Type) && matchesKeywords(m.Keywords, a.Title)
+}
@@ -0,0 +1,29 @@
+package models
+
+// PushKeys are the keys generated by the browser for a push subscription
+type PushKeys struct {
+	P256dh string `dynamo:"p256dh" json:"p256dh"`
+	Auth   string `dynamo:"auth" json:"auth"`
+}
+
+// PushSubscription is the subscription sent by the browser's PushManager,
+// along with the article types to be notified of
+type PushSubscription struct {
+	Endpoint string        `json:"endpoint"`
+	Keys     PushKeys      `json:"keys"`
+	Types    []ArticleType `json:"article_types,omitempty"`
+}
+
+// PushNotification is the payload shown by the service worker
+type PushNotification struct {
+	Title string `json:"title"`
+	Body  string `json:"body,omitempty"`
+	URL   string `json:"url"`
+	Icon  string `json:"icon,omitempty"`
+	Tag   string `json:"tag"`
+}
+
+// VAPIDKey is the application server key used by browsers to subscribe
+type VAPIDKey struct {
+	PublicKey string `json:"public_key"`
+}
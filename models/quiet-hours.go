@@ -0,0 +1,92 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+const quietHoursClock = "15:04"
+
+// QuietHours is a daily window, in the subscriber's timezone, during which
+// non-urgent articles are held back until the window ends
+type QuietHours struct {
+	Start          string        `dynamo:"start" json:"start"` // HH:MM
+	End            string        `dynamo:"end" json:"end"`     // HH:MM
+	Timezone       string        `dynamo:"timezone" json:"timezone"`
+	UrgentTypes    []ArticleType `dynamo:"urgent-types" json:"urgent_types,omitempty"`
+	UrgentKeywords []string      `dynamo:"urgent-keywords" json:"urgent_keywords,omitempty"`
+}
+
+// Validate returns an error if the window or timezone cannot be parsed
+func (q QuietHours) Validate() error {
+	_, err := time.Parse(quietHoursClock, q.Start)
+	if err != nil {
+		return errors.New("quiet_hours start must be formatted as HH:MM")
+	}
+	_, err = time.Parse(quietHoursClock, q.End)
+	if err != nil {
+		return errors.New("quiet_hours end must be formatted as HH:MM")
+	}
+	// Local would follow the timezone of whatever host delivers the articles
+	_, err = time.LoadLocation(q.Timezone)
+	if err != nil || q.Timezone == "Local" {
+		return errors.New("quiet_hours timezone is unknown")
+	}
+	return nil
+}
+
+// IsUrgent returns true if the article bypasses quiet hours
+func (q QuietHours) IsUrgent(a Article) bool {
+	for _, t := range q.UrgentTypes {
+		if t == a.Type {
+			return true
+		}
+	}
+
+	title := strings.ToLower(a.Title)
+	for _, k := range q.UrgentKeywords {
+		if strings.Contains(title, strings.ToLower(k)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Until returns the end of the window if t falls within quiet hours
+func (q QuietHours) Until(t time.Time) (time.Time, bool) {
+	loc, err := time.LoadLocation(q.Timezone)
+	if err != nil {
+		return t, false
+	}
+	start, err := time.Parse(quietHoursClock, q.Start)
+	if err != nil {
+		return t, false
+	}
+	end, err := time.Parse(quietHoursClock, q.End)
+	if err != nil {
+		return t, false
+	}
+
+	local := t.In(loc)
+	y, m, d := local.Date()
+	startToday := time.Date(y, m, d, start.Hour(), start.Minute(), 0, 0, loc)
+	endToday := time.Date(y, m, d, end.Hour(), end.Minute(), 0, 0, loc)
+
+	if !startToday.After(endToday) {
+		// window within the day, e.g. 01:00-07:00
+		if !local.Before(startToday) && local.Before(endToday) {
+			return endToday, true
+		}
+		return t, false
+	}
+
+	// window spanning midnight, e.g. 23:00-08:00
+	if local.Before(endToday) {
+		return endToday, true
+	}
+	if !local.Before(startToday) {
+		return endToday.AddDate(0, 0, 1), true
+	}
+	return t, false
+}
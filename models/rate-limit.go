@@ -0,0 +1,16 @@
+package models
+
+// RateLimit table const
+const (
+	RateLimitTable      = "kr-rate-limits"
+	RateLimitKeyCol     = "key"
+	RateLimitCountCol   = "count"
+	RateLimitExpiresCol = "expires"
+)
+
+// RateLimit counts the requests of a client within a fixed window
+type RateLimit struct {
+	Key     string `dynamo:"key"` // primary partition key, <scope>:<client>:<window start>
+	Count   int    `dynamo:"count"`
+	Expires int64  `dynamo:"expires"` // unix time, the table's TTL attribute
+}
@@ -0,0 +1,27 @@
+package models
+
+// ScrapeResult is the outcome of scraping a single category
+type ScrapeResult struct {
+	Type        string `json:"type"`
+	Fetched     int    `json:"fetched"`
+	New         int    `json:"new"`
+	Edited      int    `json:"edited"`
+	Unchanged   int    `json:"unchanged"`
+	NotModified bool   `json:"not_modified,omitempty"` // the cafe answered 304, nothing was parsed
+	Error       string `json:"error,omitempty"`
+}
+
+// ScrapeReport is returned by /scrape with a result per category
+type ScrapeReport struct {
+	Categories []ScrapeResult `json:"categories"`
+}
+
+// Failed returns true if any category could not be scraped or stored
+func (r ScrapeReport) Failed() bool {
+	for _, c := range r.Categories {
+		if c.Error != "" {
+			return true
+		}
+	}
+	return false
+}
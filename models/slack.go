@@ -0,0 +1,40 @@
+package models
+
+// Slack block and element types
+const (
+	SlackHeader    = "header"
+	SlackSection   = "section"
+	SlackContext   = "context"
+	SlackDivider   = "divider"
+	SlackImage     = "image"
+	SlackPlainText = "plain_text"
+	SlackMarkdown  = "mrkdwn"
+)
+
+// SlackText is a plain_text or mrkdwn text object
+type SlackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// SlackElement is a text or image element used in context blocks and accessories
+type SlackElement struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+	AltText  string `json:"alt_text,omitempty"`
+}
+
+// SlackBlock is a Block Kit layout block
+type SlackBlock struct {
+	Type      string         `json:"type"`
+	Text      *SlackText     `json:"text,omitempty"`
+	Accessory *SlackElement  `json:"accessory,omitempty"`
+	Elements  []SlackElement `json:"elements,omitempty"`
+}
+
+// SlackMessage is the payload sent to a slack incoming webhook
+type SlackMessage struct {
+	Text   string       `json:"text"` // notification fallback
+	Blocks []SlackBlock `json:"blocks"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// Snapshot table const
+const (
+	SnapshotTable        = "kr-snapshots"
+	SnapshotArticleIDCol = "article-id"
+	SnapshotRevisionCol  = "revision"
+)
+
+// Snapshot is an archived copy of an article's page, taken when the article
+// was first seen and on each detected revision
+type Snapshot struct {
+	ArticleID    int       `dynamo:"article-id" json:"article_id"` // primary partition key
+	Revision     int       `dynamo:"revision" json:"revision"`     // primary sort key
+	PageURL      string    `dynamo:"page-url" json:"page_url"`
+	RawKey       string    `dynamo:"raw-key" json:"-"` // both copies are only served through the API
+	SanitizedKey string    `dynamo:"sanitized-key" json:"-"`
+	Hash         string    `dynamo:"hash" json:"hash"` // sha256 of the raw page
+	Size         int       `dynamo:"size" json:"size"`
+	CapturedOn   time.Time `dynamo:"captured-on" json:"captured_on"`
+}
@@ -0,0 +1,124 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Subscription table const
+const (
+	SubscriptionTable     = "kr-subscriptions"
+	SubscriptionIDCol     = "subscription-id"
+	SubscriptionDigestCol = "last-digest-on"
+)
+
+// Subscription kinds
+const (
+	SubscriptionDiscord = "discord"
+	SubscriptionWebhook = "webhook"
+	SubscriptionSlack   = "slack"
+	SubscriptionMatrix  = "matrix"
+	SubscriptionEmail   = "email"
+	SubscriptionPush    = "push"
+)
+
+// Subscription delivery frequencies
+const (
+	FrequencyImmediate = "immediate"
+	FrequencyDaily     = "daily"
+	FrequencyWeekly    = "weekly"
+)
+
+// Subscription represents a webhook target that receives
+// the articles matching its filters
+type Subscription struct {
+	ID         string        `dynamo:"subscription-id" json:"subscription_id"` // primary partition key
+	Name       string        `dynamo:"name" json:"name"`
+	Kind       string        `dynamo:"kind" json:"kind"`
+	WebhookURL string        `dynamo:"webhook-url" json:"webhook_url"`
+	Secret     string        `dynamo:"secret" json:"secret,omitempty"` // HMAC key for generic webhooks and unsubscribe links
+	Email      string        `dynamo:"email" json:"email,omitempty"`
+	RoomID     string        `dynamo:"room-id" json:"room_id,omitempty"`
+	Token      string        `dynamo:"token" json:"token,omitempty"` // matrix access token
+	Thumbnails bool          `dynamo:"thumbnails" json:"thumbnails,omitempty"`
+	PushKeys   *PushKeys     `dynamo:"push-keys" json:"push_keys,omitempty"`
+	Types      []ArticleType `dynamo:"article-types" json:"article_types,omitempty"`
+	Regions    []string      `dynamo:"regions" json:"regions,omitempty"`
+	Keywords   []string      `dynamo:"keywords" json:"keywords,omitempty"`
+
+	StaffReplies bool `dynamo:"staff-replies" json:"staff_replies,omitempty"` // notified of staff comments
+
+	Templates MessageTemplates `dynamo:"templates" json:"templates,omitempty"`
+	Mentions  []Mention        `dynamo:"mentions" json:"mentions,omitempty"`
+
+	QuietHours   *QuietHours `dynamo:"quiet-hours" json:"quiet_hours,omitempty"`
+	Frequency    string      `dynamo:"frequency" json:"frequency,omitempty"`
+	LastDigestOn *time.Time  `dynamo:"last-digest-on" json:"last_digest_on,omitempty"` // nil until the first digest
+	CreatedOn    time.Time   `dynamo:"created-on" json:"created_on"`
+}
+
+// Redacted returns the subscription without its secrets
+func (s Subscription) Redacted() Subscription {
+	s.Secret = ""
+	s.Token = ""
+	s.PushKeys = nil
+	return s
+}
+
+// IsDigest returns true if the subscriber receives periodic digests
+// instead of immediate notifications
+func (s Subscription) IsDigest() bool {
+	return s.Frequency == FrequencyDaily || s.Frequency == FrequencyWeekly
+}
+
+// QuietUntil returns the end of the subscriber's quiet hours if t falls
+// within them and the article is not urgent
+func (s Subscription) QuietUntil(t time.Time, a Article) (time.Time, bool) {
+	if s.QuietHours == nil || s.QuietHours.IsUrgent(a) {
+		return t, false
+	}
+	return s.QuietHours.Until(t)
+}
+
+// Matches returns true if the article passes all of the subscription's filters.
+// An empty filter matches everything.
+func (s Subscription) Matches(a Article) bool {
+	return matchesType(s.Types, a.Type) && matchesRegion(s.Regions, a.Region) && matchesKeywords(s.Keywords, a.Title)
+}
+
+func matchesType(types []ArticleType, t ArticleType) bool {
+	if len(types) == 0 {
+		return true
+	}
+	for _, st := range types {
+		if st == t {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesRegion(regions []string, region string) bool {
+	if len(regions) == 0 {
+		return true
+	}
+	for _, r := range regions {
+		if strings.EqualFold(r, region) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesKeywords(keywords []string, title string) bool {
+	if len(keywords) == 0 {
+		return true
+	}
+	t := strings.ToLower(title)
+	for _, k := range keywords {
+		if strings.Contains(t, strings.ToLower(k)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,18 @@
+package models
+
+// MessageTemplates holds the text/template sources used to format
+// a subscriber's messages. An empty template keeps the default formatting.
+type MessageTemplates struct {
+	Content     string `dynamo:"content" json:"content,omitempty"`
+	Title       string `dynamo:"title" json:"title,omitempty"`
+	Description string `dynamo:"description" json:"description,omitempty"`
+	Footer      string `dynamo:"footer" json:"footer,omitempty"`
+	Author      string `dynamo:"author" json:"author,omitempty"`
+}
+
+// TemplatePreviewRequest asks for templates to be rendered against a stored article
+type TemplatePreviewRequest struct {
+	ArticleID      int              `json:"article_id"`
+	SubscriptionID string           `json:"subscription_id,omitempty"`
+	Templates      MessageTemplates `json:"templates"`
+}
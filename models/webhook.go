@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+// WebhookEvent is the JSON payload posted to generic webhook subscribers.
+// It is kept apart from ArticleEvent so the payload does not change with the
+// JSON of the query endpoints.
+type WebhookEvent struct {
+	ID        string           `json:"id"`
+	Type      ArticleEventType `json:"type"`
+	Timestamp time.Time        `json:"timestamp"`
+	Article   WebhookArticle   `json:"article"`
+	Comment   *Comment         `json:"comment,omitempty"` // set on staff replies
+}
+
+// WebhookArticle is the article of a WebhookEvent
+type WebhookArticle struct {
+	ID             int         `json:"article_id"`
+	Type           ArticleType `json:"article_type"`
+	Title          string      `json:"article_title"`
+	Desc           string      `json:"article_description"`
+	ImgURL         string      `json:"article_thumb_url"`
+	Region         string      `json:"article_region"`
+	Revision       int         `json:"revision"`
+	Author         string      `json:"article_author,omitempty"`
+	PublishedOn    *time.Time  `json:"article_published_on,omitempty"`
+	Body           string      `json:"article_body,omitempty"`
+	OriginalImgURL string      `json:"article_original_thumb_url,omitempty"`
+}
+
+// NewWebhookEvent returns the webhook payload of the event
+func NewWebhookEvent(e ArticleEvent) WebhookEvent {
+	a := e.Article
+	return WebhookEvent{
+		ID:        e.ID,
+		Type:      e.Type,
+		Timestamp: e.Timestamp,
+		Article: WebhookArticle{
+			ID:             a.ID,
+			Type:           a.Type,
+			Title:          a.Title,
+			Desc:           a.Desc,
+			ImgURL:         a.ImgURL,
+			Region:         a.Region,
+			Revision:       a.Revision,
+			Author:         a.Author,
+			PublishedOn:    a.PublishedOn,
+			Body:           a.Body,
+			OriginalImgURL: a.OriginalImgURL,
+		},
+		Comment: e.Comment,
+	}
+}
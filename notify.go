@@ -0,0 +1,216 @@
+package main
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/mweagle/Sparta/aws/dynamodb"
+	"github.com/xeia/Kings-Raid-Crawler/crawler"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"strconv"
+	"time"
+)
+
+// DynamoDB stream event names
+const (
+	streamInsert = "INSERT"
+	streamModify = "MODIFY"
+	streamRemove = "REMOVE"
+)
+
+// notifyFunc delivers article events to a single subscriber, returning the
+// result of each event so that deliveries can be completed individually
+type notifyFunc func(sub models.Subscription, events []models.ArticleEvent, logger *logrus.Logger) []error
+
+// notifiers maps a subscription kind to the function delivering its events
+var notifiers = map[string]notifyFunc{
+	models.SubscriptionDiscord: notifyDiscord,
+	models.SubscriptionWebhook: notifyWebhook,
+	models.SubscriptionSlack:   notifySlack,
+	models.SubscriptionMatrix:  notifyMatrix,
+	models.SubscriptionEmail:   notifyEmail,
+	models.SubscriptionPush:    notifyPush,
+}
+
+// notifySubscribers enqueues the article changes in the stream event for every
+// matching subscriber and attempts to deliver them. Failed deliveries are kept
+// in the outbox to be retried, so an error is only returned if the deliveries
+// could not be persisted.
+func notifySubscribers(ev dynamodb.Event, logger *logrus.Logger) error {
+	return notifyEvents(parseStreamEvents(ev, logger), logger)
+}
+
+// notifyEvents enqueues and delivers the events to the matching subscribers
+func notifyEvents(events []models.ArticleEvent, logger *logrus.Logger) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	subs, err := getSubscribers()
+	if err != nil {
+		return err
+	}
+
+	deliveries, err := enqueueDeliveries(subs, events)
+	if err != nil {
+		return err
+	}
+
+	// deliveries queued for quiet hours are flushed by the retry worker
+	var pending []models.Delivery
+	for _, d := range deliveries {
+		if d.Status == models.DeliveryPending {
+			pending = append(pending, d)
+		}
+	}
+	if queued := len(deliveries) - len(pending); queued > 0 {
+		logger.WithFields(logrus.Fields{
+			"Queued": queued,
+		}).Info("Deliveries queued for quiet hours")
+	}
+
+	failed := 0
+	for _, d := range processDeliveries(pending, subs, logger) {
+		if d.Status != models.DeliveryDelivered {
+			failed++
+		}
+	}
+	if failed > 0 {
+		logger.WithFields(logrus.Fields{
+			"Failed": failed,
+		}).Warn("Deliveries left in outbox for retry")
+	}
+	return nil
+}
+
+// parseStreamEvents converts the stream records into article events
+func parseStreamEvents(ev dynamodb.Event, logger *logrus.Logger) []models.ArticleEvent {
+	var events []models.ArticleEvent
+	for _, rec := range ev.Records {
+		e := models.ArticleEvent{ID: rec.EventID, Timestamp: time.Now()}
+		img := rec.DynamoDB.NewImage
+		switch rec.EventName {
+		case streamInsert:
+			e.Type = models.ArticleNew
+		case streamModify:
+			// without the old image, rewrites cannot be told apart from edits
+			if rec.DynamoDB.OldImage == nil {
+				logger.WithFields(logrus.Fields{
+					"EventID": rec.EventID,
+				}).Warn("Skipping modification without old image, the stream must use NEW_AND_OLD_IMAGES")
+				continue
+			}
+			// articles rewritten without a new revision, e.g. by a backfill, were not edited
+			if recordNumber(rec.DynamoDB.OldImage, models.ArticleRevisionCol) == recordNumber(img, models.ArticleRevisionCol) {
+				continue
+			}
+			e.Type = models.ArticleEdited
+		case streamRemove:
+			e.Type = models.ArticleRemoved
+			img = rec.DynamoDB.OldImage
+		default:
+			continue
+		}
+		if img == nil {
+			continue
+		}
+
+		a, err := parseImageArticle(img)
+		if err != nil {
+			logger.Error(err)
+			continue
+		}
+		e.Article = a
+		events = append(events, e)
+	}
+	return events
+}
+
+// parseImageArticle reads the article from a stream record's image
+func parseImageArticle(img map[string]dynamodb.AttributeValue) (models.Article, error) {
+	var article models.Article
+	article.Title = recordString(img, "article-title")
+	article.Desc = recordString(img, "article-description")
+	article.ImgURL = recordString(img, "article-thumb-url")
+	article.OriginalImgURL = recordString(img, "article-original-thumb-url")
+	article.Body = recordString(img, "article-body")
+	article.BodyHTML = recordString(img, "article-body-html")
+	article.CreatedOn = recordTime(img, "created-on")
+	article.ModifiedOn = recordTime(img, "modified-on")
+	article.Author = recordString(img, "article-author")
+	if published := recordTime(img, "published-on"); !published.IsZero() {
+		article.PublishedOn = &published
+	}
+
+	article.Region = recordString(img, models.ArticleRegionCol)
+	if article.Region == "" {
+		article.Region = crawler.Region
+	}
+
+	articleID, err := strconv.Atoi(recordNumber(img, models.ArticleIDCol))
+	if err != nil {
+		return article, err
+	}
+	article.ID = articleID
+
+	articleType, err := strconv.Atoi(recordNumber(img, models.ArticleTypeCol))
+	if err != nil {
+		return article, err
+	}
+	article.Type = models.ArticleType(articleType)
+
+	// articles stored before revisions were tracked have none
+	article.Revision, _ = strconv.Atoi(recordNumber(img, models.ArticleRevisionCol))
+
+	return article, nil
+}
+
+func recordString(img map[string]dynamodb.AttributeValue, key string) string {
+	if v, ok := img[key]; ok && v.S != nil {
+		return *v.S
+	}
+	return ""
+}
+
+func recordNumber(img map[string]dynamodb.AttributeValue, key string) string {
+	if v, ok := img[key]; ok && v.N != nil {
+		return *v.N
+	}
+	return ""
+}
+
+// recordTime reads a time stored by dynamo in RFC 3339 format
+func recordTime(img map[string]dynamodb.AttributeValue, key string) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, recordString(img, key))
+	return t
+}
+
+// subscriptionKind returns the kind of the subscription, defaulting to discord
+func subscriptionKind(sub models.Subscription) string {
+	if sub.Kind == "" {
+		return models.SubscriptionDiscord
+	}
+	return sub.Kind
+}
+
+// filterEvents returns the events whose article matches the subscription's
+// filters. Staff replies are only sent to subscribers who opted in.
+func filterEvents(sub models.Subscription, events []models.ArticleEvent) []models.ArticleEvent {
+	var res []models.ArticleEvent
+	for _, e := range events {
+		if e.Type == models.StaffReply && !sub.StaffReplies {
+			continue
+		}
+		if sub.Matches(e.Article) {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// failEvents returns the result of n events that all failed with err
+func failEvents(n int, err error) []error {
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
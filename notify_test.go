@@ -0,0 +1,45 @@
+package main
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/mweagle/Sparta/aws/dynamodb"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"testing"
+)
+
+func testArticleImage(revision string) map[string]dynamodb.AttributeValue {
+	id, typ, title := "1234", "1", "Maintenance"
+	return map[string]dynamodb.AttributeValue{
+		models.ArticleIDCol:       {N: &id},
+		models.ArticleTypeCol:     {N: &typ},
+		models.ArticleRevisionCol: {N: &revision},
+		"article-title":           {S: &title},
+	}
+}
+
+func TestParseStreamEventsModify(t *testing.T) {
+	tests := []struct {
+		name string
+		old  map[string]dynamodb.AttributeValue
+		want int
+	}{
+		{"edited", testArticleImage("1"), 1},
+		{"rewritten", testArticleImage("2"), 0},
+		{"no old image", nil, 0},
+	}
+
+	for _, tt := range tests {
+		ev := dynamodb.Event{Records: []dynamodb.EventRecord{{
+			EventID:   "1",
+			EventName: streamModify,
+			DynamoDB:  dynamodb.StreamRecord{NewImage: testArticleImage("2"), OldImage: tt.old},
+		}}}
+		events := parseStreamEvents(ev, logrus.New())
+		if len(events) != tt.want {
+			t.Errorf("%s: got %d event(s), want %d", tt.name, len(events), tt.want)
+		}
+		if len(events) == 1 && events[0].Type != models.ArticleEdited {
+			t.Errorf("%s: type %s", tt.name, events[0].Type)
+		}
+	}
+}
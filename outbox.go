@@ -0,0 +1,350 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/guregu/dynamo"
+	"github.com/mweagle/Sparta"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"net/http"
+	"time"
+)
+
+const (
+	outboxMaxAttempts = 5
+	outboxBackoff     = 5 * time.Minute
+
+	// pendingGrace is how long a pending delivery is left to its invocation
+	// before the retry worker assumes it was interrupted and picks it up
+	pendingGrace = 5 * time.Minute
+
+	conditionalCheckFailed = "ConditionalCheckFailedException"
+	deliveryNotFound       = "delivery not found"
+)
+
+// retryDeliveries is invoked on a schedule to re-send failed deliveries that are due
+func retryDeliveries(w http.ResponseWriter, r *http.Request) {
+	logger, _ := r.Context().Value(sparta.ContextKeyLogger).(*logrus.Logger)
+	lambdaContext, _ := r.Context().Value(sparta.ContextKeyLambdaContext).(*sparta.LambdaContext)
+	logger.WithFields(logrus.Fields{
+		"RequestID": lambdaContext.AWSRequestID,
+	}).Info(requestReceived)
+
+	deliveries, err := getDueDeliveriesFromDB(time.Now().UTC())
+	if err != nil {
+		logger.Error("RetryDeliveries Error :", err.Error())
+		writeRespHeaderWithMsg(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if len(deliveries) == 0 {
+		writeRespHeaderWithMsg(w, http.StatusNoContent, "")
+		return
+	}
+
+	subs, err := getSubscribers()
+	if err != nil {
+		logger.Error("RetryDeliveries Error Subscribers :", err.Error())
+		writeRespHeaderWithMsg(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	deliveries = processDeliveries(deliveries, subs, logger)
+	writeRespJSON(w, deliveries)
+}
+
+// manageDeliveries lists deliveries by status (GET) or re-drives them (POST)
+func manageDeliveries(w http.ResponseWriter, r *http.Request) {
+	logger, _ := r.Context().Value(sparta.ContextKeyLogger).(*logrus.Logger)
+	lambdaContext, _ := r.Context().Value(sparta.ContextKeyLambdaContext).(*sparta.LambdaContext)
+	logger.WithFields(logrus.Fields{
+		"RequestID": lambdaContext.AWSRequestID,
+	}).Info(requestReceived)
+
+	if !isAuthorized(r) {
+		writeRespHeaderWithMsg(w, http.StatusUnauthorized, unauthorizedErr)
+		return
+	}
+
+	status := models.DeliveryStatus(r.URL.Query().Get("status"))
+	if status == "" {
+		status = models.DeliveryDead
+	}
+
+	var deliveries []models.Delivery
+	var err error
+	if id := r.URL.Query().Get("id"); id != "" {
+		var d models.Delivery
+		d, err = getDeliveryFromDB(id)
+		deliveries = append(deliveries, d)
+	} else {
+		deliveries, err = getDeliveriesByStatusFromDB(status)
+	}
+	if err == dynamo.ErrNotFound {
+		writeRespHeaderWithMsg(w, http.StatusNotFound, deliveryNotFound)
+		return
+	} else if err != nil {
+		writeRespHeaderWithMsg(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeRespJSON(w, deliveries)
+	case http.MethodPost:
+		subs, err := getSubscribers()
+		if err != nil {
+			writeRespHeaderWithMsg(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		// re-driven deliveries get a fresh set of attempts
+		for i := range deliveries {
+			deliveries[i].Attempts = 0
+			deliveries[i].Status = models.DeliveryPending
+		}
+		logger.WithFields(logrus.Fields{
+			"Count": len(deliveries),
+		}).Info("Re-driving deliveries")
+		writeRespJSON(w, processDeliveries(deliveries, subs, logger))
+	default:
+		writeRespHeaderWithMsg(w, http.StatusMethodNotAllowed, methodNotAllowedError)
+	}
+}
+
+// enqueueDeliveries persists a pending delivery for every subscriber matching
+// each event. Deliveries that were already enqueued, such as when a stream batch
+// is replayed or an unchanged article is written again, are skipped so that
+// subscribers never receive the same article revision twice.
+func enqueueDeliveries(subs []models.Subscription, events []models.ArticleEvent) ([]models.Delivery, error) {
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+	table := db.Table(models.DeliveryTable)
+
+	var res []models.Delivery
+	now := time.Now().UTC()
+	for _, sub := range subs {
+		// digest subscribers are sent their articles by the scheduled digest job
+		if sub.IsDigest() {
+			continue
+		}
+
+		for _, e := range filterEvents(sub, events) {
+			d := models.Delivery{
+				ID:             deliveryID(e, sub),
+				SubscriptionID: sub.ID,
+				Event:          e,
+				Status:         models.DeliveryPending,
+				NextRetry:      now.Add(pendingGrace),
+				CreatedOn:      now,
+				ModifiedOn:     now,
+			}
+
+			if until, quiet := sub.QuietUntil(now, e.Article); quiet {
+				d.Status = models.DeliveryQueued
+				d.NextRetry = until.UTC()
+			}
+
+			err := table.Put(utcDelivery(d)).If("attribute_not_exists($)", models.DeliveryIDCol).Run()
+			if isConditionalCheckErr(err) {
+				continue
+			} else if err != nil {
+				return res, err
+			}
+			res = append(res, d)
+		}
+	}
+	return res, nil
+}
+
+// replayDeliveries returns a delivery with a fresh set of attempts for every
+// subscriber matching each event, reusing the deliveries already in the outbox
+// so that replays are recorded against the original delivery
+func replayDeliveries(subs []models.Subscription, events []models.ArticleEvent) ([]models.Delivery, error) {
+	var res []models.Delivery
+	now := time.Now().UTC()
+	for _, sub := range subs {
+		if sub.IsDigest() {
+			continue
+		}
+
+		for _, e := range filterEvents(sub, events) {
+			d, err := getDeliveryFromDB(deliveryID(e, sub))
+			if err == dynamo.ErrNotFound {
+				d = models.Delivery{
+					ID:             deliveryID(e, sub),
+					SubscriptionID: sub.ID,
+					Event:          e,
+					CreatedOn:      now,
+				}
+			} else if err != nil {
+				return res, err
+			}
+			d.Attempts = 0
+			d.Status = models.DeliveryPending
+			d.ModifiedOn = now
+			d.Event.Replay++
+			res = append(res, d)
+		}
+	}
+	return res, nil
+}
+
+// processDeliveries sends the deliveries grouped by subscriber and stores their outcome
+func processDeliveries(deliveries []models.Delivery, subs []models.Subscription, logger *logrus.Logger) []models.Delivery {
+	subMap := make(map[string]models.Subscription)
+	for _, sub := range subs {
+		subMap[sub.ID] = sub
+	}
+
+	var order []string
+	grouped := make(map[string][]models.Delivery)
+	for _, d := range deliveries {
+		if _, ok := grouped[d.SubscriptionID]; !ok {
+			order = append(order, d.SubscriptionID)
+		}
+		grouped[d.SubscriptionID] = append(grouped[d.SubscriptionID], d)
+	}
+
+	var res []models.Delivery
+	for _, subID := range order {
+		ds := grouped[subID]
+		var events []models.ArticleEvent
+		for _, d := range ds {
+			e := d.Event
+			e.Article.Edited = e.Type == models.ArticleEdited
+			events = append(events, e)
+		}
+
+		// deliveries to removed or unsupported subscribers are never retried
+		var errs []error
+		sub, ok := subMap[subID]
+		if !ok {
+			errs = failEvents(len(ds), permanentError{errors.New(subscriptionNotFound)})
+		} else if notify, ok := notifiers[subscriptionKind(sub)]; !ok {
+			errs = failEvents(len(ds), permanentError{errors.New(subscriptionKindErr)})
+		} else {
+			errs = notify(sub, events, logger)
+		}
+
+		for i, d := range ds {
+			err := errs[i]
+			if err != nil {
+				logger.WithFields(logrus.Fields{
+					"SubscriptionID": subID,
+					"DeliveryID":     d.ID,
+				}).Error("Notify Error Send :", err.Error())
+			}
+
+			_, permanent := err.(permanentError)
+			d = completeDelivery(d, err, !permanent)
+			updateErr := putDeliveryToDB(d)
+			if updateErr != nil {
+				logger.WithFields(logrus.Fields{
+					"DeliveryID": d.ID,
+				}).Error("Outbox Error Update :", updateErr.Error())
+			}
+			res = append(res, d)
+		}
+	}
+	return res
+}
+
+// permanentError marks a failure that retrying cannot fix, such as a webhook
+// rejecting the request with a client error or a removed subscriber
+type permanentError struct {
+	error
+}
+
+// completeDelivery records the outcome of an attempt, scheduling the next retry
+// with exponential backoff or giving up once the attempts are exhausted
+func completeDelivery(d models.Delivery, err error, retryable bool) models.Delivery {
+	now := time.Now().UTC()
+	d.Attempts++
+	d.ModifiedOn = now
+
+	if err == nil {
+		d.Status = models.DeliveryDelivered
+		d.LastError = ""
+		return d
+	}
+
+	d.LastError = err.Error()
+	if !retryable || d.Attempts >= outboxMaxAttempts {
+		d.Status = models.DeliveryDead
+		return d
+	}
+
+	d.Status = models.DeliveryFailed
+	d.NextRetry = now.Add(outboxBackoff * time.Duration(1<<uint(d.Attempts-1)))
+	return d
+}
+
+// deliveryID is the idempotency key of the delivery of an event to a subscriber,
+// formatted as <article id>:<revision>:<event type>:<subscription id>. Staff
+// replies are keyed by comment, as <article id>:c<comment id>:<event type>:<subscription id>.
+func deliveryID(e models.ArticleEvent, sub models.Subscription) string {
+	if e.Comment != nil {
+		return fmt.Sprintf("%d:c%d:%s:%s", e.Article.ID, e.Comment.ID, e.Type, sub.ID)
+	}
+	return fmt.Sprintf("%d:%d:%s:%s", e.Article.ID, e.Article.Revision, e.Type, sub.ID)
+}
+
+func isConditionalCheckErr(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == conditionalCheckFailed
+}
+
+func putDeliveryToDB(d models.Delivery) error {
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+	table := db.Table(models.DeliveryTable)
+	return table.Put(utcDelivery(d)).Run()
+}
+
+// utcDelivery converts the times of the delivery to UTC. Times are stored as
+// RFC 3339 strings, which only compare chronologically with the same offset.
+func utcDelivery(d models.Delivery) models.Delivery {
+	d.NextRetry = d.NextRetry.UTC()
+	d.CreatedOn = d.CreatedOn.UTC()
+	d.ModifiedOn = d.ModifiedOn.UTC()
+	return d
+}
+
+func getDeliveryFromDB(id string) (models.Delivery, error) {
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+
+	var d models.Delivery
+	table := db.Table(models.DeliveryTable)
+	err := table.Get(models.DeliveryIDCol, id).One(&d)
+	return d, err
+}
+
+func getDeliveriesByStatusFromDB(status models.DeliveryStatus) ([]models.Delivery, error) {
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+
+	var res []models.Delivery
+	table := db.Table(models.DeliveryTable)
+	err := table.Scan().Filter("$ = ?", models.DeliveryStatusCol, status).All(&res)
+	return res, err
+}
+
+// getDueDeliveriesFromDB returns the failed, queued or interrupted pending
+// deliveries whose next retry is before t
+func getDueDeliveriesFromDB(t time.Time) ([]models.Delivery, error) {
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+
+	var res []models.Delivery
+	table := db.Table(models.DeliveryTable)
+	err := table.Scan().
+		Filter("$ IN (?, ?, ?) AND $ <= ?", models.DeliveryStatusCol,
+			models.DeliveryFailed, models.DeliveryPending, models.DeliveryQueued, models.DeliveryNextRetryCol, t.UTC()).
+		All(&res)
+	return res, err
+}
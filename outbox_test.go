@@ -0,0 +1,62 @@
+package main
+
+import (
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"testing"
+	"time"
+)
+
+// next retries are compared as strings by dynamo, so they must sort like the times they hold
+func TestUTCDeliveryComparesAsString(t *testing.T) {
+	now := time.Date(2018, 5, 1, 9, 0, 0, 0, time.UTC)
+	zones := []*time.Location{time.FixedZone("PDT", -7*3600), time.FixedZone("KST", 9*3600)}
+	for _, loc := range zones {
+		for _, offset := range []time.Duration{-3 * time.Hour, 3 * time.Hour} {
+			d := utcDelivery(models.Delivery{NextRetry: now.Add(offset).In(loc)})
+			stored, _ := d.NextRetry.MarshalText()
+			cutoff, _ := now.MarshalText()
+
+			due := string(stored) <= string(cutoff)
+			if due != (offset < 0) {
+				t.Errorf("%s %v: stored %s, due = %v", loc, offset, stored, due)
+			}
+		}
+	}
+}
+
+func TestCompleteDelivery(t *testing.T) {
+	d := completeDelivery(models.Delivery{Attempts: 1}, errTest, true)
+	if d.Status != models.DeliveryFailed || d.Attempts != 2 || d.NextRetry.Location() != time.UTC {
+		t.Errorf("retryable failure: %+v", d)
+	}
+
+	d = completeDelivery(models.Delivery{}, permanentError{errTest}, false)
+	if d.Status != models.DeliveryDead {
+		t.Errorf("permanent failure: status %s", d.Status)
+	}
+
+	d = completeDelivery(models.Delivery{Attempts: outboxMaxAttempts - 1}, errTest, true)
+	if d.Status != models.DeliveryDead {
+		t.Errorf("exhausted attempts: status %s", d.Status)
+	}
+
+	d = completeDelivery(models.Delivery{LastError: "earlier"}, nil, true)
+	if d.Status != models.DeliveryDelivered || d.LastError != "" {
+		t.Errorf("delivered: %+v", d)
+	}
+}
+
+func TestQuietHoursTimezone(t *testing.T) {
+	for tz, valid := range map[string]bool{
+		"":              true, // UTC
+		"Europe/Berlin": true,
+		"Asia/Seoul":    true,
+		"Local":         false,
+		"Mars/Olympus":  false,
+	} {
+		q := models.QuietHours{Start: "23:00", End: "08:00", Timezone: tz}
+		if err := q.Validate(); (err == nil) != valid {
+			t.Errorf("%q: %v", tz, err)
+		}
+	}
+}
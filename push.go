@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/guregu/dynamo"
+	"github.com/mweagle/Sparta"
+	"github.com/xeia/Kings-Raid-Crawler/crawler"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// push messages are dropped by the push service if not delivered within a day
+	pushTTL = 24 * time.Hour
+	// VAPID tokens may be valid for at most 24 hours
+	vapidExpiry = 12 * time.Hour
+
+	pushRecordSize = 4096
+	maxPushBody    = 1000
+	// the 86 byte header and a single record holding the payload, its delimiter and the AEAD tag
+	maxPushPayload = pushRecordSize - 86 - 1 - 16
+
+	// registrations allowed per client and in total within pushRateWindow
+	pushRateWindow        = time.Hour
+	pushRatePerClient     = 10
+	pushRateTotal         = 500
+	pushRateScope         = "push"
+	pushRateTotalClientID = "*"
+
+	pushSubscriptionErr = "push subscription must have an https endpoint of a known push service and valid keys"
+	pushPayloadErr      = "push payload too large"
+	pushRateErr         = "too many push subscriptions, try again later"
+)
+
+// pushServices are the domains of the browser push services endpoints may belong to,
+// so that the crawler cannot be used to post to arbitrary hosts
+var pushServices = []string{
+	"fcm.googleapis.com",        // Chrome, Edge, Opera
+	"android.googleapis.com",    // older Chrome
+	"push.services.mozilla.com", // Firefox
+	"push.apple.com",            // Safari
+	"notify.windows.com",        // legacy Edge (WNS)
+}
+
+var (
+	pushClient  = &http.Client{Timeout: webhookTimeout}
+	errPushGone = errors.New("push subscription expired")
+)
+
+// managePush serves the VAPID public key to browsers and stores or removes
+// their push subscriptions
+func managePush(w http.ResponseWriter, r *http.Request) {
+	logger, _ := r.Context().Value(sparta.ContextKeyLogger).(*logrus.Logger)
+	lambdaContext, _ := r.Context().Value(sparta.ContextKeyLambdaContext).(*sparta.LambdaContext)
+	logger.WithFields(logrus.Fields{
+		"RequestID": lambdaContext.AWSRequestID,
+	}).Info(requestReceived)
+
+	// browsers subscribe from the community page
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+
+	switch r.Method {
+	case http.MethodOptions:
+		writeRespHeaderWithMsg(w, http.StatusNoContent, "")
+	case http.MethodGet:
+		_, pub, err := loadVAPIDKey()
+		if err != nil {
+			logger.Error("Push Error VAPID :", err.Error())
+			writeRespHeaderWithMsg(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeRespJSON(w, models.VAPIDKey{PublicKey: base64.RawURLEncoding.EncodeToString(pub)})
+	case http.MethodPost:
+		var ps models.PushSubscription
+		err := json.NewDecoder(r.Body).Decode(&ps)
+		defer r.Body.Close()
+		if err != nil {
+			writeRespHeaderWithMsg(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if validatePushSubscription(ps.Endpoint, &ps.Keys) != nil {
+			writeRespHeaderWithMsg(w, http.StatusBadRequest, pushSubscriptionErr)
+			return
+		}
+
+		// push services reject every message signed without a valid contact,
+		// so nothing is registered until the keys are configured
+		if _, _, err := loadVAPIDKey(); err != nil {
+			logger.Error("Push Error VAPID :", err.Error())
+			writeRespHeaderWithMsg(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		// registrations are limited per client, and in total to bound the
+		// traffic any number of clients can make the crawler send
+		now := time.Now()
+		for _, client := range []string{clientIP(r), pushRateTotalClientID} {
+			limit := pushRatePerClient
+			if client == pushRateTotalClientID {
+				limit = pushRateTotal
+			}
+			allowed, err := allowRequest(pushRateScope+":"+client, limit, pushRateWindow, now)
+			if err != nil {
+				logger.Error("Push Error Rate Limit :", err.Error())
+				writeRespHeaderWithMsg(w, http.StatusServiceUnavailable, pushRateErr)
+				return
+			}
+			if !allowed {
+				logger.WithFields(logrus.Fields{
+					"Client": client,
+				}).Warn("Push registration rate limited")
+				w.Header().Set("Retry-After", strconv.Itoa(int(now.Truncate(pushRateWindow).Add(pushRateWindow).Sub(now).Seconds())+1))
+				writeRespHeaderWithMsg(w, http.StatusTooManyRequests, pushRateErr)
+				return
+			}
+		}
+
+		err = addPushSubscriptionToDB(ps)
+		if err != nil {
+			writeRespHeaderWithMsg(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeRespHeaderWithMsg(w, http.StatusCreated, "")
+	case http.MethodDelete:
+		var ps models.PushSubscription
+		err := json.NewDecoder(r.Body).Decode(&ps)
+		defer r.Body.Close()
+		if err != nil {
+			writeRespHeaderWithMsg(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// the auth secret is only known to the browser, so it proves ownership
+		sub, err := getSubscriptionFromDB(pushSubscriptionID(ps.Endpoint))
+		if err != nil || sub.PushKeys == nil ||
+			subtle.ConstantTimeCompare([]byte(sub.PushKeys.Auth), []byte(ps.Keys.Auth)) != 1 {
+			writeRespHeaderWithMsg(w, http.StatusNotFound, subscriptionNotFound)
+			return
+		}
+
+		err = removeSubscriptionFromDB(sub.ID)
+		if err != nil {
+			writeRespHeaderWithMsg(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeRespHeaderWithMsg(w, http.StatusOK, "")
+	default:
+		writeRespHeaderWithMsg(w, http.StatusMethodNotAllowed, methodNotAllowedError)
+	}
+}
+
+// notifyPush sends a push message for every new or edited article. Expired
+// subscriptions are removed.
+func notifyPush(sub models.Subscription, events []models.ArticleEvent, logger *logrus.Logger) []error {
+	errs := make([]error, len(events))
+	for i, e := range events {
+		if e.Type == models.ArticleRemoved {
+			continue
+		}
+
+		payload, err := buildPushNotification(sub, e.Article)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+
+		err = sendPush(sub, payload)
+		if err == errPushGone {
+			logger.WithFields(logrus.Fields{
+				"SubscriptionID": sub.ID,
+			}).Info("Push subscription expired, removing")
+			if rmErr := removeSubscriptionFromDB(sub.ID); rmErr != nil {
+				logger.Error("Push Error Remove :", rmErr.Error())
+			}
+			for j := i; j < len(errs); j++ {
+				errs[j] = permanentError{err}
+			}
+			return errs
+		}
+		errs[i] = err
+	}
+	return errs
+}
+
+// buildPushNotification renders the article using the subscriber's templates
+func buildPushNotification(sub models.Subscription, a models.Article) ([]byte, error) {
+	embed, err := renderEmbedFor(sub.Templates, a, 1, crawler.PlainText)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(models.PushNotification{
+		Title: embed.Title,
+		Body:  truncate(embed.Description, maxPushBody),
+		URL:   embed.URL,
+		Icon:  a.ImgURL,
+		Tag:   strconv.Itoa(a.ID),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxPushPayload {
+		return nil, errors.New(pushPayloadErr)
+	}
+	return b, nil
+}
+
+// sendPush encrypts the payload for the subscriber and sends it to the push service
+func sendPush(sub models.Subscription, payload []byte) error {
+	if sub.PushKeys == nil {
+		return errors.New(pushSubscriptionErr)
+	}
+	uaPublic, err := decodePushKey(sub.PushKeys.P256dh)
+	if err != nil {
+		return err
+	}
+	authSecret, err := decodePushKey(sub.PushKeys.Auth)
+	if err != nil {
+		return err
+	}
+
+	body, err := encryptPushPayload(uaPublic, authSecret, payload)
+	if err != nil {
+		return err
+	}
+
+	key, pub, err := loadVAPIDKey()
+	if err != nil {
+		return err
+	}
+	auth, err := vapidAuthorization(sub.WebhookURL, key, pub, time.Now())
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, sub.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(int(pushTTL.Seconds())))
+	req.Header.Set(headerAuthorization, auth)
+
+	resp, err := pushClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return errPushGone
+	default:
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("Response code received: %d %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+}
+
+// encryptPushPayload encrypts the payload in a single aes128gcm record as
+// described in RFC 8291
+func encryptPushPayload(uaPublic []byte, authSecret []byte, payload []byte) ([]byte, error) {
+	curve := ecdh.P256()
+	uaKey, err := curve.NewPublicKey(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+	asKey, err := curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := asKey.PublicKey().Bytes()
+
+	ecdhSecret, err := asKey.ECDH(uaKey)
+	if err != nil {
+		return nil, err
+	}
+
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublic...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm, err := hkdfBytes(ecdhSecret, authSecret, keyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 16)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	cek, err := hkdfBytes(ikm, salt, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdfBytes(ikm, salt, []byte("Content-Encoding: nonce\x00"), 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// header: salt, record size, key id length and the application server's public key
+	header := make([]byte, 0, 16+4+1+len(asPublic))
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, pushRecordSize)
+	header = append(header, byte(len(asPublic)))
+	header = append(header, asPublic...)
+
+	// 0x02 marks the last record
+	plaintext := append(append([]byte{}, payload...), 0x02)
+	return gcm.Seal(header, nonce, plaintext, nil), nil
+}
+
+func hkdfBytes(secret []byte, salt []byte, info []byte, n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, info), b)
+	return b, err
+}
+
+// vapidAuthorization returns the VAPID authorization header of RFC 8292 for the push service
+func vapidAuthorization(endpoint string, key *ecdsa.PrivateKey, pub []byte, now time.Time) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": now.Add(vapidExpiry).Unix(),
+		"sub": strings.TrimSpace(os.Getenv(envVAPIDSubject)),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`)) + "." + enc.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	if err != nil {
+		return "", err
+	}
+
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return fmt.Sprintf("vapid t=%s.%s, k=%s", unsigned, enc.EncodeToString(sig), enc.EncodeToString(pub)), nil
+}
+
+// loadVAPIDKey returns the VAPID private key and its uncompressed public key,
+// failing as well when the subject push services require is not usable
+func loadVAPIDKey() (*ecdsa.PrivateKey, []byte, error) {
+	if err := validateVAPIDSubject(strings.TrimSpace(os.Getenv(envVAPIDSubject))); err != nil {
+		return nil, nil, err
+	}
+
+	d, err := decodePushKey(os.Getenv(envVAPIDPrivateKey))
+	if err != nil {
+		return nil, nil, errors.New(envVAPIDErr)
+	}
+	priv, err := ecdh.P256().NewPrivateKey(d)
+	if err != nil {
+		return nil, nil, errors.New(envVAPIDErr)
+	}
+
+	pub := priv.PublicKey().Bytes()
+	key := &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(pub[1:33]),
+			Y:     new(big.Int).SetBytes(pub[33:]),
+		},
+		D: new(big.Int).SetBytes(d),
+	}
+	return key, pub, nil
+}
+
+// validateVAPIDSubject checks that the subject is the contact RFC 8292 asks for
+func validateVAPIDSubject(subject string) error {
+	u, err := url.Parse(subject)
+	if err != nil {
+		return errors.New(envVAPIDSubjectErr)
+	}
+	switch {
+	case u.Scheme == "mailto" && strings.Contains(u.Opaque, "@"):
+		return nil
+	case u.Scheme == "https" && u.Host != "":
+		return nil
+	}
+	return errors.New(envVAPIDSubjectErr)
+}
+
+// generateVAPIDKey returns a new private key and its public key, base64url encoded
+func generateVAPIDKey() (string, string, error) {
+	priv, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(priv.Bytes()), enc.EncodeToString(priv.PublicKey().Bytes()), nil
+}
+
+// decodePushKey decodes a base64url key, with or without padding
+func decodePushKey(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// pushSubscriptionID derives the subscription ID from the endpoint, so a
+// browser subscribing again replaces its previous subscription
+func pushSubscriptionID(endpoint string) string {
+	h := sha256.Sum256([]byte(endpoint))
+	return "push-" + hex.EncodeToString(h[:8])
+}
+
+// isPushService returns true if the host belongs to one of the known push services
+func isPushService(host string) bool {
+	host = strings.ToLower(host)
+	for _, d := range pushServices {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePushSubscription checks the endpoint and the browser's keys
+func validatePushSubscription(endpoint string, keys *models.PushKeys) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme != "https" || (u.Port() != "" && u.Port() != "443") || !isPushService(u.Hostname()) || keys == nil {
+		return errors.New(pushSubscriptionErr)
+	}
+
+	p256dh, err := decodePushKey(keys.P256dh)
+	if err != nil {
+		return errors.New(pushSubscriptionErr)
+	}
+	_, err = ecdh.P256().NewPublicKey(p256dh)
+	if err != nil {
+		return errors.New(pushSubscriptionErr)
+	}
+
+	auth, err := decodePushKey(keys.Auth)
+	if err != nil || len(auth) != 16 {
+		return errors.New(pushSubscriptionErr)
+	}
+	return nil
+}
+
+func addPushSubscriptionToDB(ps models.PushSubscription) error {
+	keys := ps.Keys
+	sub := models.Subscription{
+		ID:         pushSubscriptionID(ps.Endpoint),
+		Name:       "browser",
+		Kind:       models.SubscriptionPush,
+		WebhookURL: ps.Endpoint,
+		PushKeys:   &keys,
+		Types:      ps.Types,
+		Frequency:  models.FrequencyImmediate,
+		CreatedOn:  time.Now(),
+	}
+	err := validateSubscription(sub)
+	if err != nil {
+		return err
+	}
+
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+	table := db.Table(models.SubscriptionTable)
+	return table.Put(sub).Run()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"testing"
+)
+
+func TestValidatePushSubscription(t *testing.T) {
+	priv, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	rand.Read(auth)
+	keys := &models.PushKeys{
+		P256dh: base64.RawURLEncoding.EncodeToString(priv.PublicKey().Bytes()),
+		Auth:   base64.RawURLEncoding.EncodeToString(auth),
+	}
+
+	tests := []struct {
+		endpoint string
+		ok       bool
+	}{
+		{"https://fcm.googleapis.com/fcm/send/abc", true},
+		{"https://updates.push.services.mozilla.com/wpush/v2/abc", true},
+		{"https://web.push.apple.com/abc", true},
+		{"https://db5p.notify.windows.com/w/?token=abc", true},
+		{"https://fcm.googleapis.com:443/fcm/send/abc", true},
+		{"http://fcm.googleapis.com/fcm/send/abc", false},
+		{"https://fcm.googleapis.com:8443/fcm/send/abc", false},
+		{"https://example.com/fcm.googleapis.com", false},
+		{"https://evilfcm.googleapis.com.example.com/abc", false},
+		{"https://169.254.169.254/latest/meta-data", false},
+		{"https://localhost/abc", false},
+	}
+	for _, tt := range tests {
+		if err := validatePushSubscription(tt.endpoint, keys); (err == nil) != tt.ok {
+			t.Errorf("validatePushSubscription(%s) = %v, want ok = %v", tt.endpoint, err, tt.ok)
+		}
+	}
+
+	if err := validatePushSubscription("https://fcm.googleapis.com/fcm/send/abc", &models.PushKeys{}); err == nil {
+		t.Error("accepted a subscription without keys")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	r := newLambdaRequest("POST", "/push", nil)
+	r.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+	if got := clientIP(r); got != "203.0.113.7" {
+		t.Errorf("clientIP = %q", got)
+	}
+
+	r = newLambdaRequest("POST", "/push", nil)
+	if got := clientIP(r); got != "192.0.2.1" {
+		t.Errorf("clientIP without forwarding = %q", got)
+	}
+}
+
+func TestValidateVAPIDSubject(t *testing.T) {
+	tests := map[string]bool{
+		"mailto:admin@example.com":    true,
+		"https://example.com/contact": true,
+		"":                            false,
+		"admin@example.com":           false,
+		"mailto:":                     false,
+		"http://example.com":          false,
+		"https://":                    false,
+		"example.com":                 false,
+	}
+	for subject, ok := range tests {
+		if err := validateVAPIDSubject(subject); (err == nil) != ok {
+			t.Errorf("validateVAPIDSubject(%q) = %v, want ok = %v", subject, err, ok)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/guregu/dynamo"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// allowRequest counts a request against the key's limit in the current fixed
+// window, returning false once the limit is exceeded. Counters are shared by
+// every lambda instance and expire through the table's TTL.
+func allowRequest(key string, limit int, window time.Duration, now time.Time) (bool, error) {
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+	table := db.Table(models.RateLimitTable)
+
+	start := now.Truncate(window)
+	var rl models.RateLimit
+	err := table.Update(models.RateLimitKeyCol, fmt.Sprintf("%s:%d", key, start.Unix())).
+		Add(models.RateLimitCountCol, 1).
+		Set(models.RateLimitExpiresCol, start.Add(2*window).Unix()).
+		Value(&rl)
+	if err != nil {
+		return false, err
+	}
+	return rl.Count <= limit, nil
+}
+
+// clientIP returns the address of the client, as forwarded by API Gateway.
+// Clients may send their own X-Forwarded-For, so limits per client must be
+// paired with a total limit.
+func clientIP(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		return strings.TrimSpace(strings.Split(fwd, ",")[0])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"github.com/Sirupsen/logrus"
+	"github.com/xeia/Kings-Raid-Crawler/crawler"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"sync"
+)
+
+// maximum number of categories scraped at the same time
+const scrapeParallelism = 2
+
+// scrapeCategories scrapes and stores the categories concurrently. Categories
+// still waiting for a slot when ctx is done report its error instead.
+func scrapeCategories(ctx context.Context, types []models.ArticleType, logger *logrus.Logger) models.ScrapeReport {
+	results := make([]models.ScrapeResult, len(types))
+	sem := make(chan struct{}, scrapeParallelism)
+
+	var wg sync.WaitGroup
+	for i, typ := range types {
+		wg.Add(1)
+		go func(i int, typ models.ArticleType) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+				results[i] = scrapeCategory(ctx, typ, logger)
+			case <-ctx.Done():
+				results[i] = models.ScrapeResult{Type: typ.String(), Error: ctx.Err().Error()}
+			}
+		}(i, typ)
+	}
+	wg.Wait()
+
+	return models.ScrapeReport{Categories: results}
+}
+
+// scrapeCategory scrapes a category and stores its new and edited articles
+func scrapeCategory(ctx context.Context, typ models.ArticleType, logger *logrus.Logger) models.ScrapeResult {
+	res := models.ScrapeResult{Type: typ.String()}
+	if err := ctx.Err(); err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	articles, cHash, save, err := scrapeType(ctx, typ, logger)
+	if err == crawler.ErrNotModified {
+		res.NotModified = true
+		return res
+	} else if err != nil {
+		logger.WithFields(logrus.Fields{
+			"ArticleType": typ.String(),
+		}).Error("Scrape Error :", err.Error())
+		res.Error = err.Error()
+		return res
+	}
+
+	res.Fetched = len(articles)
+	if len(articles) < 1 || isArticleStateUnchanged(cHash, typ, articles[0].ID) {
+		saveValidators(save, typ, logger)
+		res.Unchanged = res.Fetched
+		return res
+	}
+
+	stored, err := addArticlesToDB(ctx, articles)
+	for _, a := range stored {
+		if a.Revision > 1 {
+			res.Edited++
+		} else {
+			res.New++
+		}
+		logger.WithFields(logrus.Fields{
+			"ArticleType":     typ.String(),
+			"ArticleID":       a.ID,
+			"ArticleTitle":    a.Title,
+			"ArticleRevision": a.Revision,
+		}).Info("Scrape Stored")
+	}
+	if err != nil {
+		logger.WithFields(logrus.Fields{
+			"ArticleType": typ.String(),
+		}).Error("Scrape Error Add :", err.Error())
+		res.Error = err.Error()
+		return res
+	}
+	saveValidators(save, typ, logger)
+	res.Unchanged = res.Fetched - res.New - res.Edited
+	return res
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/xeia/Kings-Raid-Crawler/crawler"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// slack message limits
+const (
+	maxSlackBlocks     = 50
+	maxSlackHeader     = 150
+	maxSlackSection    = 3000
+	maxSlackText       = 3000
+	maxSlackRetries    = 2
+	maxSlackRetryAfter = 30 * time.Second
+
+	// blocks used per article: header, section, context and divider
+	slackBlocksPerArticle = 4
+
+	slackUntitled = "New post on the PLUG cafe"
+)
+
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+var slackClient = &http.Client{Timeout: webhookTimeout}
+
+// notifySlack posts new and edited articles to the subscriber's slack incoming webhook
+func notifySlack(sub models.Subscription, events []models.ArticleEvent, logger *logrus.Logger) []error {
+	errs := make([]error, len(events))
+	var articles []models.Article
+	var indexes []int
+	for i, e := range events {
+		if e.Type != models.ArticleRemoved {
+			articles = append(articles, e.Article)
+			indexes = append(indexes, i)
+		}
+	}
+
+	// each message only fails the events it carries
+	perMessage := maxSlackBlocks / slackBlocksPerArticle
+	for i := 0; i < len(articles); i += perMessage {
+		end := i + perMessage
+		if end > len(articles) {
+			end = len(articles)
+		}
+
+		msg, err := buildSlackMessage(sub, articles[i:end])
+		if err == nil {
+			err = sendSlackMessage(sub.WebhookURL, msg, logger)
+		}
+		for _, idx := range indexes[i:end] {
+			errs[idx] = err
+		}
+	}
+	return errs
+}
+
+// buildSlackMessage converts the articles into Block Kit blocks,
+// using the subscriber's templates for the title and description
+func buildSlackMessage(sub models.Subscription, articles []models.Article) (models.SlackMessage, error) {
+	var msg models.SlackMessage
+	var titles []string
+
+	for _, a := range articles {
+		embed, err := renderEmbedFor(sub.Templates, a, len(articles), crawler.SlackMrkdwn)
+		if err != nil {
+			return msg, err
+		}
+		// header and section text are required, so articles without a title
+		// or description fall back to a generic one
+		title := strings.TrimSpace(embed.Title)
+		if title == "" {
+			title = slackUntitled
+		}
+		titles = append(titles, title)
+
+		msg.Blocks = append(msg.Blocks, models.SlackBlock{
+			Type: models.SlackHeader,
+			Text: &models.SlackText{Type: models.SlackPlainText, Text: truncate(title, maxSlackHeader)},
+		})
+
+		// descriptions rendered from the article are already in slack's syntax
+		desc := strings.TrimSpace(embed.Description)
+		if desc == "" {
+			desc = slackEscape(title)
+		} else if sub.Templates.Description != "" {
+			desc = slackEscape(desc)
+		}
+		section := models.SlackBlock{
+			Type: models.SlackSection,
+			Text: &models.SlackText{Type: models.SlackMarkdown, Text: truncateMrkdwn(desc, maxSlackSection)},
+		}
+		if a.ImgURL != "" {
+			section.Accessory = &models.SlackElement{Type: models.SlackImage, ImageURL: a.ImgURL, AltText: title}
+		}
+		msg.Blocks = append(msg.Blocks, section)
+
+		context := []models.SlackElement{
+			{Type: models.SlackMarkdown, Text: "*" + a.Type.String() + "*"},
+			{Type: models.SlackMarkdown, Text: fmt.Sprintf("<%s|View on PLUG cafe>", embed.URL)},
+		}
+		if embed.Footer.Text != "" {
+			context = append(context, models.SlackElement{Type: models.SlackMarkdown, Text: slackEscape(embed.Footer.Text)})
+		}
+		msg.Blocks = append(msg.Blocks,
+			models.SlackBlock{Type: models.SlackContext, Elements: context},
+			models.SlackBlock{Type: models.SlackDivider},
+		)
+	}
+
+	msg.Text = truncate(strings.Join(titles, "\n"), maxSlackText)
+	return msg, nil
+}
+
+// sendSlackMessage posts the message, waiting for the duration given by
+// slack when rate limited
+func sendSlackMessage(webhookURL string, msg models.SlackMessage, logger *logrus.Logger) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(http.MethodPost, webhookURL, bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := slackClient.Do(req)
+		if err != nil {
+			return err
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusOK {
+			return nil
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < maxSlackRetries {
+			wait := slackRetryAfter(resp.Header.Get("Retry-After"))
+			if wait <= maxSlackRetryAfter {
+				logger.WithFields(logrus.Fields{
+					"RetryAfter": wait,
+				}).Warn("Slack rate limited")
+				time.Sleep(wait)
+				continue
+			}
+		}
+		return fmt.Errorf("Response code received: %d %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+}
+
+// slackRetryAfter parses the Retry-After header, given in seconds
+func slackRetryAfter(h string) time.Duration {
+	s, err := strconv.Atoi(h)
+	if err != nil || s < 1 {
+		return time.Second
+	}
+	return time.Duration(s) * time.Second
+}
+
+// slackEscape escapes the control characters of slack's mrkdwn
+func slackEscape(s string) string {
+	return slackEscaper.Replace(s)
+}
+
+// truncateMrkdwn truncates escaped mrkdwn text without splitting an HTML
+// entity or a <url|text> link, which slack would show as is
+func truncateMrkdwn(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n || n <= 3 {
+		return truncate(s, n)
+	}
+
+	cut := string(r[:n-3])
+	if i := strings.LastIndex(cut, "&"); i > strings.LastIndex(cut, ";") {
+		cut = cut[:i]
+	}
+	if i := strings.LastIndex(cut, "<"); i > strings.LastIndex(cut, ">") {
+		cut = cut[:i]
+	}
+	return cut + "..."
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/Sirupsen/logrus"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+)
+
+// slackDouble is a stand-in incoming webhook recording the messages it receives
+type slackDouble struct {
+	mu       sync.Mutex
+	messages []models.SlackMessage
+	status   func(n int) int // status of the nth message
+}
+
+func (d *slackDouble) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var msg models.SlackMessage
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, "invalid_payload", http.StatusBadRequest)
+		return
+	}
+
+	d.mu.Lock()
+	d.messages = append(d.messages, msg)
+	n := len(d.messages)
+	d.mu.Unlock()
+
+	// slack rejects blocks whose text is empty
+	for _, b := range msg.Blocks {
+		if b.Text != nil && strings.TrimSpace(b.Text.Text) == "" {
+			http.Error(w, "invalid_blocks", http.StatusBadRequest)
+			return
+		}
+	}
+	if d.status != nil {
+		if s := d.status(n); s != http.StatusOK {
+			http.Error(w, "failure", s)
+			return
+		}
+	}
+	w.Write([]byte("ok"))
+}
+
+func slackEvents(articles ...models.Article) []models.ArticleEvent {
+	var events []models.ArticleEvent
+	for _, a := range articles {
+		events = append(events, models.ArticleEvent{Type: models.ArticleNew, Article: a})
+	}
+	return events
+}
+
+func TestNotifySlackBlocks(t *testing.T) {
+	double := &slackDouble{}
+	srv := httptest.NewServer(double)
+	defer srv.Close()
+
+	sub := models.Subscription{ID: "slack", Kind: models.SubscriptionSlack, WebhookURL: srv.URL}
+	events := slackEvents(
+		models.Article{ID: 1, Type: models.NOTICE, Title: "Maintenance <tonight> & more", Desc: "Servers are down", ImgURL: "https://example.com/a.png"},
+		models.Article{ID: 2, Type: models.EVENTS, ImgURL: "https://example.com/b.png"},
+	)
+	for i, err := range notifySlack(sub, events, logrus.New()) {
+		if err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+	}
+
+	if len(double.messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(double.messages))
+	}
+	blocks := double.messages[0].Blocks
+	if len(blocks) != 2*slackBlocksPerArticle {
+		t.Fatalf("got %d blocks, want %d", len(blocks), 2*slackBlocksPerArticle)
+	}
+
+	types := []string{models.SlackHeader, models.SlackSection, models.SlackContext, models.SlackDivider}
+	for i, b := range blocks {
+		if b.Type != types[i%len(types)] {
+			t.Errorf("block %d is a %s, want %s", i, b.Type, types[i%len(types)])
+		}
+	}
+	if got := blocks[0].Text.Text; got != "Maintenance <tonight> & more" {
+		t.Errorf("header = %q, plain text must not be escaped", got)
+	}
+	if got := blocks[1].Text.Text; got != "Servers are down" {
+		t.Errorf("section = %q", got)
+	}
+	if blocks[1].Accessory == nil || blocks[1].Accessory.ImageURL != "https://example.com/a.png" {
+		t.Errorf("accessory = %+v", blocks[1].Accessory)
+	}
+	if got := blocks[4].Text.Text; got != slackUntitled {
+		t.Errorf("untitled header = %q", got)
+	}
+	if got := blocks[5].Text.Text; got != slackUntitled {
+		t.Errorf("section without title or description = %q", got)
+	}
+	if blocks[5].Accessory == nil || blocks[5].Accessory.AltText != slackUntitled {
+		t.Errorf("accessory of an untitled article = %+v, slack rejects an empty alt_text", blocks[5].Accessory)
+	}
+}
+
+func TestNotifySlackTruncation(t *testing.T) {
+	double := &slackDouble{}
+	srv := httptest.NewServer(double)
+	defer srv.Close()
+
+	sub := models.Subscription{ID: "slack", Kind: models.SubscriptionSlack, WebhookURL: srv.URL}
+	a := models.Article{ID: 1, Type: models.NOTICE, Title: strings.Repeat("T", 200), Desc: strings.Repeat("a & b ", 1000)}
+	if err := notifySlack(sub, slackEvents(a), logrus.New())[0]; err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := double.messages[0].Blocks
+	if n := utf8.RuneCountInString(blocks[0].Text.Text); n > maxSlackHeader {
+		t.Errorf("header of %d characters", n)
+	}
+	section := blocks[1].Text.Text
+	if n := utf8.RuneCountInString(section); n > maxSlackSection {
+		t.Errorf("section of %d characters", n)
+	}
+	if !strings.HasSuffix(section, "...") {
+		t.Errorf("section is not marked as truncated: %q", section[len(section)-20:])
+	}
+	body := strings.TrimSuffix(section, "...")
+	if i := strings.LastIndex(body, "&"); i >= 0 && !strings.HasPrefix(body[i:], "&amp;") {
+		t.Errorf("section ends with a split entity: %q", body[len(body)-20:])
+	}
+}
+
+func TestNotifySlackPartialFailure(t *testing.T) {
+	double := &slackDouble{status: func(n int) int {
+		if n == 2 {
+			return http.StatusInternalServerError
+		}
+		return http.StatusOK
+	}}
+	srv := httptest.NewServer(double)
+	defer srv.Close()
+
+	perMessage := maxSlackBlocks / slackBlocksPerArticle
+	var articles []models.Article
+	for i := 1; i <= perMessage+1; i++ {
+		articles = append(articles, models.Article{ID: i, Type: models.PATCHNOTES, Title: "Patch"})
+	}
+
+	sub := models.Subscription{ID: "slack", Kind: models.SubscriptionSlack, WebhookURL: srv.URL}
+	errs := notifySlack(sub, slackEvents(articles...), logrus.New())
+	if len(double.messages) != 2 {
+		t.Fatalf("got %d messages, want 2", len(double.messages))
+	}
+	for i, err := range errs {
+		if failed := i >= perMessage; (err != nil) != failed {
+			t.Errorf("event %d: error %v, want failed = %v", i, err, failed)
+		}
+	}
+}
+
+func TestTruncateMrkdwn(t *testing.T) {
+	tests := []struct {
+		in   string
+		n    int
+		want string
+	}{
+		{"short &amp; sweet", 50, "short &amp; sweet"},
+		{"fish &amp; chips", 10, "fish ..."},
+		{"see <https://x.io|link> now", 15, "see ..."},
+		{"a &lt;b&gt; c d e", 15, "a &lt;b&gt; ..."},
+	}
+	for _, tt := range tests {
+		if got := truncateMrkdwn(tt.in, tt.n); got != tt.want {
+			t.Errorf("truncateMrkdwn(%q, %d) = %q, want %q", tt.in, tt.n, got, tt.want)
+		}
+	}
+}
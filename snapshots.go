@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/guregu/dynamo"
+	"github.com/mweagle/Sparta"
+	"github.com/xeia/Kings-Raid-Crawler/crawler"
+	"github.com/xeia/Kings-Raid-Crawler/mirror"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	snapshotContentType = "text/html; charset=utf-8"
+
+	snapshotPathErr  = "expected /get/{id}/snapshots or /get/{id}/snapshots/{revision}"
+	snapshotNotFound = "snapshot not found"
+)
+
+// snapshotStore is nil unless SNAPSHOT_BUCKET or SNAPSHOT_DIR is set. It is
+// kept apart from the public image mirror, as the raw pages are unsanitized.
+var snapshotStore mirror.BlobStore
+
+// captureSnapshots archives the page of each new or edited article. Failures
+// are only logged, as they must not hold back the notifications.
+func captureSnapshots(ctx context.Context, events []models.ArticleEvent, logger *logrus.Logger) {
+	if snapshotStore == nil {
+		return
+	}
+
+	for _, e := range events {
+		if e.Type == models.ArticleRemoved {
+			continue
+		}
+
+		err := captureSnapshot(ctx, e.Article)
+		if err != nil {
+			logger.WithFields(logrus.Fields{
+				"ArticleID": e.Article.ID,
+				"Revision":  e.Article.Revision,
+			}).Warn("Snapshot Error :", err.Error())
+		}
+	}
+}
+
+// captureSnapshot stores the raw page of the article's current revision and a
+// sanitized copy referencing mirrored images. Revisions already archived, e.g.
+// when a stream batch is retried, are skipped.
+func captureSnapshot(ctx context.Context, a models.Article) error {
+	_, err := getSnapshotFromDB(a.ID, a.Revision)
+	if err == nil {
+		return nil
+	} else if err != dynamo.ErrNotFound {
+		return err
+	}
+
+	page, err := crawler.Default.ArticlePage(ctx, a.ID)
+	if err != nil {
+		return err
+	}
+	pageURL := crawler.Default.ArticlePageURL(a.ID)
+
+	sanitized, err := crawler.Sanitize(page, pageURL, mirrorImages(ctx, a.ID))
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(page)
+	s := models.Snapshot{
+		ArticleID:    a.ID,
+		Revision:     a.Revision,
+		PageURL:      pageURL,
+		RawKey:       fmt.Sprintf("snapshots/%d/%d.raw.html", a.ID, a.Revision),
+		SanitizedKey: fmt.Sprintf("snapshots/%d/%d.html", a.ID, a.Revision),
+		Hash:         hex.EncodeToString(sum[:]),
+		Size:         len(page),
+		CapturedOn:   time.Now(),
+	}
+	_, err = snapshotStore.Put(ctx, s.RawKey, snapshotContentType, page)
+	if err != nil {
+		return err
+	}
+	_, err = snapshotStore.Put(ctx, s.SanitizedKey, snapshotContentType, sanitized)
+	if err != nil {
+		return err
+	}
+	return putSnapshotToDB(s)
+}
+
+// articleSnapshots lists the snapshots of an article on /get/{id}/snapshots,
+// and downloads one on /get/{id}/snapshots/{revision}. The sanitized copy is
+// served unless ?format=raw is given.
+func articleSnapshots(w http.ResponseWriter, r *http.Request) {
+	logger, _ := r.Context().Value(sparta.ContextKeyLogger).(*logrus.Logger)
+	lambdaContext, _ := r.Context().Value(sparta.ContextKeyLambdaContext).(*sparta.LambdaContext)
+	logger.WithFields(logrus.Fields{
+		"RequestID": lambdaContext.AWSRequestID,
+	}).Info(requestReceived)
+
+	if r.Method != http.MethodGet {
+		writeRespHeaderWithMsg(w, http.StatusMethodNotAllowed, methodNotAllowedError)
+		return
+	}
+
+	id, revision, err := parseSnapshotPath(r.URL.Path)
+	if err != nil {
+		writeRespHeaderWithMsg(w, http.StatusBadRequest, snapshotPathErr)
+		return
+	}
+
+	if revision == 0 {
+		snapshots, err := getSnapshotsFromDB(id)
+		if err != nil {
+			writeRespHeaderWithMsg(w, http.StatusInternalServerError, dbReadErr)
+			return
+		}
+		writeRespJSON(w, snapshots)
+		return
+	}
+
+	s, err := getSnapshotFromDB(id, revision)
+	if err == dynamo.ErrNotFound || snapshotStore == nil {
+		writeRespHeaderWithMsg(w, http.StatusNotFound, snapshotNotFound)
+		return
+	} else if err != nil {
+		writeRespHeaderWithMsg(w, http.StatusInternalServerError, dbReadErr)
+		return
+	}
+
+	key, name := s.SanitizedKey, fmt.Sprintf("article-%d-r%d.html", id, revision)
+	if r.URL.Query().Get("format") == "raw" {
+		key, name = s.RawKey, fmt.Sprintf("article-%d-r%d.raw.html", id, revision)
+	}
+	page, err := snapshotStore.Get(r.Context(), key)
+	if err == mirror.ErrNotFound {
+		writeRespHeaderWithMsg(w, http.StatusNotFound, snapshotNotFound)
+		return
+	} else if err != nil {
+		logger.Error("Snapshot Error Get :", err.Error())
+		writeRespHeaderWithMsg(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// raw pages contain the cafe's scripts, which must not run on our domain
+	w.Header().Set("Content-Type", snapshotContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Write(page)
+}
+
+// parseSnapshotPath reads the article ID and the optional revision from
+// /get/{id}/snapshots[/{revision}], returning a revision of 0 if there is none
+func parseSnapshotPath(p string) (int, int, error) {
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "snapshots" {
+			continue
+		}
+
+		id, err := strconv.Atoi(parts[i-1])
+		if err != nil {
+			return 0, 0, err
+		}
+		switch len(parts) - i {
+		case 1:
+			return id, 0, nil
+		case 2:
+			revision, err := strconv.Atoi(parts[i+1])
+			if err != nil || revision < 1 {
+				return 0, 0, fmt.Errorf("invalid revision: %s", parts[i+1])
+			}
+			return id, revision, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("invalid snapshot path: %s", p)
+}
+
+func getSnapshotFromDB(id int, revision int) (models.Snapshot, error) {
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+
+	var s models.Snapshot
+	table := db.Table(models.SnapshotTable)
+	err := table.Get(models.SnapshotArticleIDCol, id).Range(models.SnapshotRevisionCol, dynamo.Equal, revision).One(&s)
+	return s, err
+}
+
+func getSnapshotsFromDB(id int) ([]models.Snapshot, error) {
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+
+	snapshots := []models.Snapshot{}
+	table := db.Table(models.SnapshotTable)
+	err := table.Get(models.SnapshotArticleIDCol, id).All(&snapshots)
+	return snapshots, err
+}
+
+func putSnapshotToDB(s models.Snapshot) error {
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+	table := db.Table(models.SnapshotTable)
+	return table.Put(s).Run()
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/guregu/dynamo"
+	"github.com/mweagle/Sparta"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	headerAuthorization = "Authorization"
+	bearerPrefix        = "Bearer "
+
+	// defaultSubscriptionID identifies the subscriber configured through DISCORD_WEBHOOK
+	defaultSubscriptionID = "default"
+
+	unauthorizedErr        = "missing or invalid admin token"
+	subscriptionURLErr     = "webhook_url must be an absolute https url, or http on loopback"
+	subscriptionTypeErr    = "unknown article type in article_types"
+	subscriptionIDErr      = "missing subscription id"
+	subscriptionNotFound   = "subscription not found"
+	subscriptionKindErr    = "unknown subscription kind"
+	subscriptionFreqErr    = "frequency must be immediate, daily or weekly"
+	subscriptionDigestErr  = "digests are not supported for this subscription kind"
+	subscriptionMentionErr = "mentions must be discord role or user IDs"
+	subscriptionMatrixErr  = "room_id and token are required for matrix subscriptions"
+	subscriptionEmailErr   = "email must be a valid address"
+	methodNotAllowedError  = "method not allowed"
+)
+
+func manageSubscriptions(w http.ResponseWriter, r *http.Request) {
+	logger, _ := r.Context().Value(sparta.ContextKeyLogger).(*logrus.Logger)
+	lambdaContext, _ := r.Context().Value(sparta.ContextKeyLambdaContext).(*sparta.LambdaContext)
+	logger.WithFields(logrus.Fields{
+		"RequestID": lambdaContext.AWSRequestID,
+	}).Info(requestReceived)
+
+	if !isAuthorized(r) {
+		writeRespHeaderWithMsg(w, http.StatusUnauthorized, unauthorizedErr)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		subs, err := getSubscriptionsFromDB()
+		if err != nil {
+			writeRespHeaderWithMsg(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		// secrets are only returned when the subscription is added
+		for i := range subs {
+			subs[i] = subs[i].Redacted()
+		}
+		writeRespJSON(w, subs)
+	case http.MethodPost:
+		var sub models.Subscription
+		defer r.Body.Close()
+		err := json.NewDecoder(r.Body).Decode(&sub)
+		if err != nil {
+			writeRespHeaderWithMsg(w, http.StatusBadRequest, eventReadErr+err.Error())
+			return
+		}
+
+		sub, err = addSubscriptionToDB(sub)
+		if err != nil {
+			writeRespHeaderWithMsg(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		logger.WithFields(logrus.Fields{
+			"SubscriptionID": sub.ID,
+		}).Info("Subscription added")
+		writeRespJSON(w, sub)
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		err := removeSubscriptionFromDB(id)
+		if err != nil {
+			writeRespHeaderWithMsg(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		logger.WithFields(logrus.Fields{
+			"SubscriptionID": id,
+		}).Info("Subscription removed")
+		writeRespHeaderWithMsg(w, http.StatusNoContent, "")
+	default:
+		writeRespHeaderWithMsg(w, http.StatusMethodNotAllowed, methodNotAllowedError)
+	}
+}
+
+// isAuthorized returns true if the request carries the admin bearer token
+func isAuthorized(r *http.Request) bool {
+	token := os.Getenv(envAdminToken)
+	if token == "" {
+		return false
+	}
+
+	auth := r.Header.Get(headerAuthorization)
+	if !strings.HasPrefix(auth, bearerPrefix) {
+		return false
+	}
+	given := strings.TrimPrefix(auth, bearerPrefix)
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// isLoopback returns true for local hosts, which may be served over plain
+// http when testing against a stand-in server
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func validateSubscription(sub models.Subscription) error {
+	if _, ok := notifiers[subscriptionKind(sub)]; !ok {
+		return errors.New(subscriptionKindErr)
+	}
+
+	switch sub.Frequency {
+	case "", models.FrequencyImmediate:
+	case models.FrequencyDaily, models.FrequencyWeekly:
+		if _, ok := digesters[subscriptionKind(sub)]; !ok {
+			return errors.New(subscriptionDigestErr)
+		}
+	default:
+		return errors.New(subscriptionFreqErr)
+	}
+
+	switch sub.Kind {
+	case models.SubscriptionEmail:
+		addr, err := mail.ParseAddress(sub.Email)
+		if err != nil || addr.Address != sub.Email {
+			return errors.New(subscriptionEmailErr)
+		}
+	case models.SubscriptionPush:
+		err := validatePushSubscription(sub.WebhookURL, sub.PushKeys)
+		if err != nil {
+			return err
+		}
+	default:
+		u, err := url.Parse(sub.WebhookURL)
+		if err != nil || u.Host == "" || (u.Scheme != "https" && !(u.Scheme == "http" && isLoopback(u.Hostname()))) {
+			return errors.New(subscriptionURLErr)
+		}
+	}
+	if sub.Kind == models.SubscriptionMatrix && (sub.RoomID == "" || sub.Token == "") {
+		return errors.New(subscriptionMatrixErr)
+	}
+
+	for _, t := range sub.Types {
+		if t < models.NOTICE || t > models.PATCHNOTES {
+			return errors.New(subscriptionTypeErr)
+		}
+	}
+	for _, m := range sub.Mentions {
+		for _, t := range m.Types {
+			if t < models.NOTICE || t > models.PATCHNOTES {
+				return errors.New(subscriptionTypeErr)
+			}
+		}
+		for _, id := range append(m.Roles, m.Users...) {
+			if !isSnowflake(id) {
+				return errors.New(subscriptionMentionErr)
+			}
+		}
+	}
+
+	if sub.QuietHours != nil {
+		err := sub.QuietHours.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	return validateTemplates(sub.Templates)
+}
+
+func generateSubscriptionID() (string, error) {
+	return randomHex(8)
+}
+
+func generateSecret() (string, error) {
+	return randomHex(32)
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func addSubscriptionToDB(sub models.Subscription) (models.Subscription, error) {
+	err := validateSubscription(sub)
+	if err != nil {
+		return sub, err
+	}
+
+	sub.Kind = subscriptionKind(sub)
+	if sub.Frequency == "" {
+		sub.Frequency = models.FrequencyImmediate
+	}
+	sub.ID, err = generateSubscriptionID()
+	if err != nil {
+		return sub, err
+	}
+	if (sub.Kind == models.SubscriptionWebhook || sub.Kind == models.SubscriptionEmail) && sub.Secret == "" {
+		sub.Secret, err = generateSecret()
+		if err != nil {
+			return sub, err
+		}
+	}
+	sub.CreatedOn = time.Now()
+
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+	table := db.Table(models.SubscriptionTable)
+	err = table.Put(sub).If("attribute_not_exists($)", models.SubscriptionIDCol).Run()
+	return sub, err
+}
+
+func removeSubscriptionFromDB(id string) error {
+	if id == "" {
+		return errors.New(subscriptionIDErr)
+	}
+
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+	table := db.Table(models.SubscriptionTable)
+	err := table.Delete(models.SubscriptionIDCol, id).If("attribute_exists($)", models.SubscriptionIDCol).Run()
+	if err != nil {
+		return errors.New(subscriptionNotFound)
+	}
+	return nil
+}
+
+func getSubscriptionFromDB(id string) (models.Subscription, error) {
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+
+	var sub models.Subscription
+	table := db.Table(models.SubscriptionTable)
+	err := table.Get(models.SubscriptionIDCol, id).One(&sub)
+	return sub, err
+}
+
+func getSubscriptionsFromDB() ([]models.Subscription, error) {
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+
+	var res []models.Subscription
+	table := db.Table(models.SubscriptionTable)
+	err := table.Scan().All(&res)
+	return res, err
+}
+
+// getSubscribers returns the stored subscriptions along with the
+// unfiltered subscriber configured through DISCORD_WEBHOOK, if any
+func getSubscribers() ([]models.Subscription, error) {
+	subs, err := getSubscriptionsFromDB()
+	if dh := os.Getenv(envDiscordHook); dh != "" {
+		subs = append(subs, models.Subscription{
+			ID:         defaultSubscriptionID,
+			Name:       envDiscordHook,
+			Kind:       models.SubscriptionDiscord,
+			WebhookURL: dh,
+		})
+	}
+	return subs, err
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/Sirupsen/logrus"
+	"github.com/mweagle/Sparta"
+	"github.com/xeia/Kings-Raid-Crawler/crawler"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// discord message limits
+const (
+	maxDiscordContent     = 2000
+	maxDiscordTitle       = 256
+	maxDiscordDescription = 2048
+	maxDiscordFooter      = 2048
+	maxDiscordAuthor      = 256
+
+	// maximum number of characters across all embeds of a message
+	maxDiscordEmbedsTotal = 6000
+)
+
+const templateArticleErr = "missing article_id"
+
+// templateData is the value templates are executed against
+type templateData struct {
+	models.Article
+	TypeName string
+	URL      string
+	Count    int // number of articles in the notification
+}
+
+var templateFuncs = template.FuncMap{
+	"upper":    strings.ToUpper,
+	"lower":    strings.ToLower,
+	"truncate": truncate,
+}
+
+// sampleArticle is used to validate templates at registration time
+var sampleArticle = models.Article{
+	ID:        1,
+	Type:      models.NOTICE,
+	Title:     "[Notice] Scheduled Maintenance",
+	Desc:      "Maintenance will take place on Wednesday.",
+	ImgURL:    crawler.CafeBase,
+	Region:    crawler.Region,
+	CreatedOn: time.Now(),
+}
+
+func previewTemplates(w http.ResponseWriter, r *http.Request) {
+	logger, _ := r.Context().Value(sparta.ContextKeyLogger).(*logrus.Logger)
+	lambdaContext, _ := r.Context().Value(sparta.ContextKeyLambdaContext).(*sparta.LambdaContext)
+	logger.WithFields(logrus.Fields{
+		"RequestID": lambdaContext.AWSRequestID,
+	}).Info(requestReceived)
+
+	if !isAuthorized(r) {
+		writeRespHeaderWithMsg(w, http.StatusUnauthorized, unauthorizedErr)
+		return
+	}
+
+	var req models.TemplatePreviewRequest
+	defer r.Body.Close()
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		writeRespHeaderWithMsg(w, http.StatusBadRequest, eventReadErr+err.Error())
+		return
+	}
+	if req.ArticleID < 1 {
+		writeRespHeaderWithMsg(w, http.StatusBadRequest, templateArticleErr)
+		return
+	}
+
+	sub := models.Subscription{Templates: req.Templates}
+	if req.SubscriptionID != "" {
+		sub, err = getSubscriptionFromDB(req.SubscriptionID)
+		if err != nil {
+			writeRespHeaderWithMsg(w, http.StatusNotFound, subscriptionNotFound)
+			return
+		}
+	}
+
+	article, err := getArticleFromDB(req.ArticleID)
+	if err != nil {
+		writeRespHeaderWithMsg(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	msgs, err := buildDiscordMessages(sub, []models.Article{article})
+	if err != nil {
+		writeRespHeaderWithMsg(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeRespJSON(w, msgs[0].Message)
+}
+
+// validateTemplates parses the templates and executes them against a sample article
+func validateTemplates(t models.MessageTemplates) error {
+	_, err := renderEmbed(t, sampleArticle, 1)
+	if err != nil {
+		return err
+	}
+	_, err = renderTemplate("content", t.Content, newTemplateData(sampleArticle, 1))
+	return err
+}
+
+func newTemplateData(a models.Article, count int) templateData {
+	return templateData{
+		Article:  a,
+		TypeName: a.Type.String(),
+		URL:      formatArticleURL(a.Type, a.ID),
+		Count:    count,
+	}
+}
+
+// renderTemplate executes src against data, returning an empty string for an empty template
+func renderTemplate(name string, src string, data templateData) (string, error) {
+	if src == "" {
+		return "", nil
+	}
+
+	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(src)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, data)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// renderEmbed formats an article as a discord embed using the subscriber's templates
+func renderEmbed(t models.MessageTemplates, a models.Article, count int) (models.DiscordEmbed, error) {
+	return renderEmbedFor(t, a, count, crawler.DiscordMarkdown)
+}
+
+// renderEmbedFor formats an article as an embed for another notifier, rendering
+// the description in its flavor unless the subscriber has a description template
+func renderEmbedFor(t models.MessageTemplates, a models.Article, count int, f crawler.Flavor) (models.DiscordEmbed, error) {
+	embed := generateArticleEmbed(a)
+	var desc string
+	if f != crawler.DiscordMarkdown {
+		desc = articleDescription(a, f)
+		embed.Description = desc
+	}
+	data := newTemplateData(a, count)
+
+	fields := []struct {
+		name  string
+		src   string
+		dst   *string
+		limit int
+	}{
+		{"title", t.Title, &embed.Title, maxDiscordTitle},
+		{"description", t.Description, &embed.Description, maxDiscordDescription},
+		{"footer", t.Footer, &embed.Footer.Text, maxDiscordFooter},
+		{"author", t.Author, &embed.Author.Name, maxDiscordAuthor},
+	}
+	for _, field := range fields {
+		v, err := renderTemplate(field.name, field.src, data)
+		if err != nil {
+			return embed, err
+		}
+		if v != "" {
+			*field.dst = v
+		}
+		*field.dst = truncate(*field.dst, field.limit)
+	}
+
+	// descriptions rendered in slack's syntax must not lose half an entity or link
+	if f == crawler.SlackMrkdwn && t.Description == "" {
+		embed.Description = truncateMrkdwn(desc, maxDiscordDescription)
+	}
+
+	embed = markEdited(embed, a)
+	embed.Footer.Text = truncate(embed.Footer.Text, maxDiscordFooter)
+	return embed, nil
+}
+
+// discordMessage is a message built for the articles[Start:End] it embeds
+type discordMessage struct {
+	Message    models.DiscordHookMessage
+	Start, End int
+}
+
+// buildDiscordMessages formats the articles into discord messages for the
+// subscriber, splitting them into several messages to respect the number of
+// embeds and the characters discord allows across the embeds of a message
+func buildDiscordMessages(sub models.Subscription, articles []models.Article) ([]discordMessage, error) {
+	var msgs []discordMessage
+	if len(articles) == 0 {
+		return msgs, errors.New(commandNoResults)
+	}
+
+	content, err := renderTemplate("content", sub.Templates.Content, newTemplateData(articles[0], len(articles)))
+	if err != nil {
+		return msgs, err
+	}
+	if content == "" {
+		content = generateContentString()
+	}
+
+	embeds := make([]models.DiscordEmbed, len(articles))
+	for i, a := range articles {
+		embeds[i], err = renderEmbed(sub.Templates, a, len(articles))
+		if err != nil {
+			return msgs, err
+		}
+	}
+
+	// a single embed is always within the total, as its fields are truncated
+	for start := 0; start < len(articles); {
+		end, budget := start, maxDiscordEmbedsTotal
+		for end < len(articles) && end-start < maxDiscordEmbeds {
+			n := discordEmbedSize(embeds[end])
+			if end > start && n > budget {
+				break
+			}
+			budget -= n
+			end++
+		}
+
+		prefix, allowed := buildMentions(sub.Mentions, articles[start:end])
+		msgs = append(msgs, discordMessage{
+			Message: models.DiscordHookMessage{
+				Content:         truncate(strings.TrimSpace(prefix+" "+content), maxDiscordContent),
+				Embeds:          embeds[start:end],
+				AllowedMentions: allowed,
+			},
+			Start: start,
+			End:   end,
+		})
+		content = ""
+		start = end
+	}
+	return msgs, nil
+}
+
+// discordEmbedSize returns the characters of the embed counted towards maxDiscordEmbedsTotal
+func discordEmbedSize(e models.DiscordEmbed) int {
+	n := len([]rune(e.Title)) + len([]rune(e.Description)) + len([]rune(e.Footer.Text)) + len([]rune(e.Author.Name))
+	for _, f := range e.Fields {
+		n += len([]rune(f.Name)) + len([]rune(f.Value))
+	}
+	return n
+}
+
+// truncate shortens s to at most n characters
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	if n <= 3 {
+		return string(r[:n])
+	}
+	return string(r[:n-3]) + "..."
+}
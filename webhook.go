@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/guregu/dynamo"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// generic webhook headers
+const (
+	headerWebhookEvent     = "X-KR-Event"
+	headerWebhookDelivery  = "X-KR-Delivery"
+	headerWebhookTimestamp = "X-KR-Timestamp"
+	headerWebhookSignature = "X-KR-Signature"
+	signaturePrefix        = "sha256="
+)
+
+// webhooks are attempted once per invocation, the outbox schedules the retries
+const webhookTimeout = 10 * time.Second
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+// notifyWebhook posts each event to the subscriber's generic webhook
+func notifyWebhook(sub models.Subscription, events []models.ArticleEvent, logger *logrus.Logger) []error {
+	errs := make([]error, len(events))
+	for i, e := range events {
+		errs[i] = deliverWebhookEvent(sub, e, logger)
+	}
+	return errs
+}
+
+// deliverWebhookEvent makes a single attempt at posting the event and records it
+func deliverWebhookEvent(sub models.Subscription, e models.ArticleEvent, logger *logrus.Logger) error {
+	body, err := json.Marshal(models.NewWebhookEvent(e))
+	if err != nil {
+		return err
+	}
+
+	id := deliveryID(e, sub)
+	previous, countErr := countDeliveryAttemptsFromDB(id)
+	if countErr != nil {
+		logger.Error("Webhook Error Count Attempts :", countErr.Error())
+	}
+
+	start := time.Now()
+	status, err := postWebhookEvent(sub, e, id, body, start)
+
+	rec := models.DeliveryAttempt{
+		DeliveryID:     id,
+		Attempt:        int(previous) + 1,
+		SubscriptionID: sub.ID,
+		EventID:        e.ID,
+		URL:            sub.WebhookURL,
+		StatusCode:     status,
+		Duration:       time.Since(start),
+		AttemptedOn:    start,
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	recErr := addDeliveryAttemptToDB(rec)
+	if recErr != nil {
+		logger.Error("Webhook Error Record Attempt :", recErr.Error())
+	}
+	if err != nil && !isRetryable(status) {
+		return permanentError{err}
+	}
+	return err
+}
+
+// postWebhookEvent signs and sends the body, returning the response status code
+func postWebhookEvent(sub models.Subscription, e models.ArticleEvent, id string, body []byte, now time.Time) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, sub.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	ts := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(headerWebhookEvent, string(e.Type))
+	req.Header.Set(headerWebhookDelivery, id)
+	req.Header.Set(headerWebhookTimestamp, strconv.FormatInt(ts, 10))
+	req.Header.Set(headerWebhookSignature, signWebhookPayload(sub.Secret, ts, body))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("Response code received: %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// signWebhookPayload returns the HMAC-SHA256 of "<timestamp>.<body>" keyed by the secret
+func signWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// isRetryable returns true for network errors (status 0), throttling and server errors
+func isRetryable(status int) bool {
+	return status == 0 || status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+func addDeliveryAttemptToDB(a models.DeliveryAttempt) error {
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+	table := db.Table(models.DeliveryAttemptTable)
+	return table.Put(a).Run()
+}
+
+func countDeliveryAttemptsFromDB(id string) (int64, error) {
+	sess := session.Must(session.NewSession())
+	db := dynamo.New(sess)
+	table := db.Table(models.DeliveryAttemptTable)
+	return table.Get(models.DeliveryAttemptIDCol, id).Count()
+}
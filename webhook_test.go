@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/xeia/Kings-Raid-Crawler/models"
+	"testing"
+)
+
+func TestArticleJSONNames(t *testing.T) {
+	a := models.Article{ID: 1234, Type: models.PATCHNOTES, Title: "Patch", Revision: 2}
+	e := models.ArticleEvent{ID: "1", Type: models.ArticleEdited, Article: a}
+
+	tests := []struct {
+		name string
+		v    interface{}
+		keys []string
+	}{
+		// the query endpoints keep the names they have always returned
+		{"article", a, []string{"ID", "Type", "Title", "Desc", "ImgURL", "CreatedOn", "ModifiedOn"}},
+		{"webhook", models.NewWebhookEvent(e).Article, []string{"article_id", "article_type", "article_title", "revision"}},
+	}
+	for _, tt := range tests {
+		b, err := json.Marshal(tt.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m map[string]interface{}
+		json.Unmarshal(b, &m)
+		for _, k := range tt.keys {
+			if _, ok := m[k]; !ok {
+				t.Errorf("%s: missing %q in %s", tt.name, k, b)
+			}
+		}
+	}
+}